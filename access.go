@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// accessCheck is the result of an access check of `Authenticate`.
+type accessCheck struct {
+	Name   string `json:"name"`
+	Pass   bool   `json:"pass"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// accessReport collects the access checks of a dry run of `Authenticate`.
+type accessReport struct {
+	Checks []accessCheck `json:"checks"`
+}
+
+// add adds a check result to the report. It is a no-op on a nil report, such that the checks of
+// `Authenticate` can report unconditionally.
+func (r *accessReport) add(name string, pass bool, reason string) {
+	if r == nil {
+		return
+	}
+	r.Checks = append(r.Checks, accessCheck{Name: name, Pass: pass, Reason: reason})
+}
+
+// allowed returns whether all the checks passed.
+func (r *accessReport) allowed() bool {
+	for _, c := range r.Checks {
+		if !c.Pass {
+			return false
+		}
+	}
+	return true
+}
+
+// withDryRun makes `Authenticate` report its access checks in the request context instead of
+// denying access.
+func withDryRun() Option {
+	return func(o *options) { o.dryRun = true }
+}
+
+// accessResponse is the response of the CanAccessHandler.
+type accessResponse struct {
+	Subject string `json:"subject"`
+	Email   string `json:"email,omitempty"`
+	// Actor is the email of the real user, when another user is impersonated.
+	Actor   string        `json:"actor,omitempty"`
+	Allowed bool          `json:"allowed"`
+	Checks  []accessCheck `json:"checks"`
+}
+
+// CanAccessHandler returns an authenticated handler that evaluates the access checks that
+// `Authenticate` applies with the given options, for the logged in user, and responds with the
+// result of each check as JSON, without denying access. It helps to debug why a user is denied.
+//
+// It is allowed only for users that pass `Config.CanDebugAccess`, other users get a not found
+// response. During impersonation, the checks are evaluated for the impersonated user, and the
+// real user should pass `Config.CanDebugAccess`.
+func (a *Auth) CanAccessHandler(opts ...Option) http.Handler {
+	opts = append(opts[:len(opts):len(opts)], withDryRun())
+	return a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creds := User(r.Context())
+		actor := Actor(r.Context())
+		admin := actor
+		if admin == nil {
+			admin = creds
+		}
+		canDebug := a.config().CanDebugAccess
+		if creds == nil || canDebug == nil || !canDebug(admin) {
+			http.NotFound(w, r)
+			return
+		}
+
+		report := accessReportFrom(r.Context())
+		resp := accessResponse{
+			Subject: creds.Subject,
+			Email:   creds.Email,
+			Allowed: report.allowed(),
+			Checks:  report.Checks,
+		}
+		if actor != nil {
+			resp.Actor = actor.Email
+		}
+		if resp.Checks == nil {
+			resp.Checks = []accessCheck{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			a.logr(r.Context(), "Failed writing access checks: %v", err)
+		}
+	}), opts...)
+}
+
+// accessReportFrom returns the access report of a dry run from the context.
+func accessReportFrom(ctx context.Context) *accessReport {
+	report, _ := ctx.Value(accessReportKey).(*accessReport)
+	if report == nil {
+		return &accessReport{}
+	}
+	return report
+}
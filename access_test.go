@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestCanAccessHandler(t *testing.T) {
+	t.Parallel()
+
+	privateKey, privateKeyCert := newTestKey(t)
+	session := newSessionCookie(t, genSignedToken(t, privateKeyCert.KID, privateKey, "client1", "email@example.com", "John"))
+
+	deny := WithAuthorize(func(*Creds) bool { return false })
+	allow := WithAuthorize(func(*Creds) bool { return true })
+	isAdmin := func(c *Creds) bool { return c.Email == "email@example.com" }
+	isOther := func(c *Creds) bool { return c.Email == "other@example.com" }
+
+	tests := []struct {
+		name           string
+		canDebugAccess func(*Creds) bool
+		opts           []Option
+		wantStatus     int
+		wantAllowed    bool
+		wantChecks     []accessCheck
+	}{
+		{
+			name:           "denied",
+			canDebugAccess: isAdmin,
+			opts:           []Option{deny},
+			wantStatus:     http.StatusOK,
+			wantChecks: []accessCheck{
+				{Name: "authorize", Pass: false, Reason: "WithAuthorize: user must pass the authorize function"},
+				{Name: "cooldown", Pass: true, Reason: "DenialThreshold: user must not be in a denial cooldown"},
+			},
+		},
+		{
+			name:           "allowed",
+			canDebugAccess: isAdmin,
+			opts:           []Option{allow},
+			wantStatus:     http.StatusOK,
+			wantAllowed:    true,
+			wantChecks: []accessCheck{
+				{Name: "authorize", Pass: true, Reason: "WithAuthorize: user must pass the authorize function"},
+				{Name: "cooldown", Pass: true, Reason: "DenialThreshold: user must not be in a denial cooldown"},
+			},
+		},
+		{
+			name:       "debug not configured",
+			opts:       []Option{deny},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:           "not allowed to debug",
+			canDebugAccess: isOther,
+			opts:           []Option{deny},
+			wantStatus:     http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(context.Background(), Config{
+				Config:          oauth2.Config{ClientID: "client1"},
+				CanDebugAccess:  tt.canDebugAccess,
+				DenialThreshold: 1,
+				Log:             t.Logf,
+				Client:          fakeClient(t, certResp{Keys: []cert{privateKeyCert}}),
+			})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/debug/access", nil)
+			req.AddCookie(session)
+			rec := httptest.NewRecorder()
+			a.CanAccessHandler(tt.opts...).ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got accessResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+			assert.Equal(t, "email@example.com", got.Email)
+			assert.Equal(t, tt.wantAllowed, got.Allowed)
+			assert.Equal(t, tt.wantChecks, got.Checks)
+
+			// The dry run does not count as a denial.
+			assert.False(t, a.coolingDown(got.Subject))
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// IsAPIRequest returns whether the request was sent by an API client rather than by browser
+// navigation: an XMLHttpRequest, a request that accepts JSON, such as "application/json" or
+// "application/problem+json", or a gRPC-Web request. It is the default `Config.APIRequest`.
+func IsAPIRequest(r *http.Request) bool {
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		return true
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc-web") {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(accept, ";", 2)[0])
+		if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") ||
+			strings.HasPrefix(mediaType, "application/grpc-web") {
+			return true
+		}
+	}
+	return false
+}
+
+// problem is an RFC 7807 problem details object.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Error is the stable name of the error, for login failures.
+	Error string `json:"error,omitempty"`
+	// ProviderError is the OAuth2 error code that was returned by the provider.
+	ProviderError string `json:"provider_error,omitempty"`
+}
+
+// ProblemLoginRequired writes an unauthorized response with an RFC 7807 "application/problem+json"
+// body. It is the default `Config.LoginRequired`.
+func ProblemLoginRequired(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusUnauthorized),
+		Status: http.StatusUnauthorized,
+		Detail: "Login is required",
+	})
+}
+
+// requireLogin starts the login flow for a request without a valid session, unless the request
+// was sent by a bot or by an API client, that can't follow the login flow.
+func (a *Auth) requireLogin(w http.ResponseWriter, r *http.Request, redirectPath string) {
+	cfg := a.config()
+	switch {
+	case cfg.IsBot != nil && cfg.IsBot(r):
+		// Keep bots out of the OAuth2 flow.
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	case cfg.APIRequest(r):
+		cfg.LoginRequired(w, r)
+	default:
+		a.login(w, r, redirectPath, false)
+	}
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestIsAPIRequest(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		header map[string]string
+		want   bool
+	}{
+		{header: map[string]string{"Accept": "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"}, want: false},
+		{header: map[string]string{}, want: false},
+		{header: map[string]string{"Accept": "application/json"}, want: true},
+		{header: map[string]string{"Accept": "application/problem+json; charset=utf-8"}, want: true},
+		{header: map[string]string{"Accept": "application/grpc-web-text"}, want: true},
+		{header: map[string]string{"Content-Type": "application/grpc-web+proto"}, want: true},
+		{header: map[string]string{"X-Requested-With": "XMLHttpRequest"}, want: true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		assert.Equal(t, tt.want, IsAPIRequest(req), tt.header)
+	}
+}
+
+func TestLoginRequired(t *testing.T) {
+	t.Parallel()
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{RedirectURL: "https://example.com/auth"},
+	})
+	require.NoError(t, err)
+	h := a.Authenticate(http.NotFoundHandler())
+
+	// Browser navigation is redirected to login.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+
+	// API clients get a problem details response.
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	var p problem
+	err = json.NewDecoder(rec.Body).Decode(&p)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, p.Status)
+
+	// Custom matcher and response.
+	a, err = New(context.Background(), Config{
+		Config:     oauth2.Config{RedirectURL: "https://example.com/auth"},
+		APIRequest: func(r *http.Request) bool { return r.URL.Path == "/api" },
+		LoginRequired: func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "login", http.StatusUnauthorized)
+		},
+	})
+	require.NoError(t, err)
+	h = a.Authenticate(http.NotFoundHandler())
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "login\n", rec.Body.String())
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/page", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// clientAssertionType is the client_assertion_type of a JWT client assertion, as defined in
+	// RFC 7523.
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// clientAssertionTTL is the lifetime of a client assertion.
+	clientAssertionTTL = time.Minute
+)
+
+// ClientAssertion returns a `Config.TokenExchangeOptions` function that authenticates the client
+// on the code exchange with a JWT client assertion (RFC 7523), signed with the given key. The
+// audience is usually the token endpoint URL.
+func ClientAssertion(key *rsa.PrivateKey, keyID, clientID, audience string) func(*http.Request) ([]oauth2.AuthCodeOption, error) {
+	return func(*http.Request) ([]oauth2.AuthCodeOption, error) {
+		assertion, err := signClientAssertion(key, keyID, clientID, audience)
+		if err != nil {
+			return nil, err
+		}
+		return []oauth2.AuthCodeOption{
+			oauth2.SetAuthURLParam("client_assertion_type", clientAssertionType),
+			oauth2.SetAuthURLParam("client_assertion", assertion),
+		}, nil
+	}
+}
+
+// signClientAssertion returns a short lived client assertion JWT.
+func signClientAssertion(key *rsa.PrivateKey, keyID, clientID, audience string) (string, error) {
+	jti := make([]byte, 16)
+	_, err := rand.Read(jti)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
+		Issuer:    clientID,
+		Subject:   clientID,
+		Audience:  audience,
+		Id:        hex.EncodeToString(jti),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(clientAssertionTTL).Unix(),
+	})
+	if keyID != "" {
+		t.Header["kid"] = keyID
+	}
+	return t.SignedString(key)
+}
+
+// clientAssertionTransport authenticates the client on form POST requests to the provider with
+// a client assertion that is signed with Config.ClientPrivateKey, when it is set.
+type clientAssertionTransport struct {
+	base http.RoundTripper
+	a    *Auth
+}
+
+func (t *clientAssertionTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	cfg := t.a.config()
+	if cfg.ClientPrivateKey == nil || r.Method != http.MethodPost || r.Body == nil ||
+		r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		return t.base.RoundTrip(r)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing provider request form: %v", err)
+	}
+	assertion, err := signClientAssertion(cfg.ClientPrivateKey, cfg.ClientKeyID, cfg.ClientID, cfg.Endpoint.TokenURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed signing client assertion: %v", err)
+	}
+	form.Set("client_id", cfg.ClientID)
+	form.Del("client_secret")
+	form.Set("client_assertion_type", clientAssertionType)
+	form.Set("client_assertion", assertion)
+	encoded := form.Encode()
+
+	// The request should not be modified by a RoundTripper.
+	r = r.Clone(r.Context())
+	r.Body = io.NopCloser(strings.NewReader(encoded))
+	r.ContentLength = int64(len(encoded))
+	r.Header.Del("Authorization")
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenExchangeOptions(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "https://api.example.com", r.PostForm.Get("resource"))
+		assert.Equal(t, clientAssertionType, r.PostForm.Get("client_assertion_type"))
+
+		claims := jwt.StandardClaims{}
+		_, err := jwt.ParseWithClaims(r.PostForm.Get("client_assertion"), &claims, func(token *jwt.Token) (interface{}, error) {
+			assert.Equal(t, "keyid", token.Header["kid"])
+			return &privateKey.PublicKey, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "client1", claims.Issuer)
+		assert.Equal(t, "client1", claims.Subject)
+		assert.Equal(t, s.URL+"/token", claims.Audience)
+		assert.NotEmpty(t, claims.Id)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"token_type": "Bearer", "access_token": "access", "id_token": "id token"})
+	}))
+	defer s.Close()
+
+	assertion := ClientAssertion(privateKey, "keyid", "client1", s.URL+"/token")
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{
+			ClientID: "client1",
+			Endpoint: oauth2.Endpoint{AuthURL: s.URL + "/auth", TokenURL: s.URL + "/token"},
+		},
+		TokenExchangeOptions: func(r *http.Request) ([]oauth2.AuthCodeOption, error) {
+			opts, err := assertion(r)
+			return append(opts, oauth2.SetAuthURLParam("resource", "https://api.example.com")), err
+		},
+		Log: t.Logf,
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	a.RedirectHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth?code=code&state=/", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+
+	// The options are not sent in the authorization URL.
+	rec = httptest.NewRecorder()
+	a.LoginHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	assert.NotContains(t, rec.Header().Get("Location"), "resource")
+}
+
+func TestClientPrivateKey(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+
+	var s *httptest.Server
+	var grants []string
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, hasBasicAuth := r.BasicAuth()
+		assert.False(t, hasBasicAuth)
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "client1", r.PostForm.Get("client_id"))
+		assert.NotContains(t, r.PostForm, "client_secret")
+		assert.Equal(t, clientAssertionType, r.PostForm.Get("client_assertion_type"))
+		claims := jwt.StandardClaims{}
+		_, err := jwt.ParseWithClaims(r.PostForm.Get("client_assertion"), &claims, func(token *jwt.Token) (interface{}, error) {
+			assert.Equal(t, "keyid", token.Header["kid"])
+			return &privateKey.PublicKey, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, s.URL+"/token", claims.Audience)
+		grants = append(grants, r.PostForm.Get("grant_type"))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token_type": "Bearer", "access_token": "access", "refresh_token": "refresh", "id_token": "id token", "expires_in": 3600,
+		})
+	}))
+	defer s.Close()
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{
+			ClientID: "client1",
+			Endpoint: oauth2.Endpoint{AuthURL: s.URL + "/auth", TokenURL: s.URL + "/token"},
+		},
+		ClientPrivateKey: privateKey,
+		ClientKeyID:      "keyid",
+		Log:              t.Logf,
+	})
+	require.NoError(t, err)
+
+	// Both the code exchange and the token refresh are authenticated with the assertion.
+	ctx := a.providerContext(context.Background())
+	token, err := a.oauth2Config("https://example.com/auth").Exchange(ctx, "code")
+	require.NoError(t, err)
+	token.Expiry = token.Expiry.Add(-2 * time.Hour)
+	_, err = a.config().TokenSource(ctx, token).Token()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"authorization_code", "refresh_token"}, grants)
+
+	_, err = New(context.Background(), Config{
+		Config:           oauth2.Config{ClientID: "client1", ClientSecret: "secret"},
+		ClientPrivateKey: privateKey,
+	})
+	assert.Error(t, err)
+	_, err = New(context.Background(), Config{
+		Config:           oauth2.Config{ClientID: "client1"},
+		ClientPrivateKey: &rsa.PrivateKey{PublicKey: privateKey.PublicKey, D: privateKey.D},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// AuditEventType is the type of an audit event.
+type AuditEventType string
+
+const (
+	// AuditLogin is recorded when a user logs in, or fails to log in.
+	AuditLogin AuditEventType = "login"
+	// AuditLogout is recorded when a user logs out, or is logged out by the provider.
+	AuditLogout AuditEventType = "logout"
+	// AuditDenied is recorded when an authenticated user is denied.
+	AuditDenied AuditEventType = "denied"
+	// AuditRefresh is recorded when a session is renewed, or fails to renew.
+	AuditRefresh AuditEventType = "refresh"
+	// AuditImpersonate is recorded when a user starts or stops impersonating another user.
+	AuditImpersonate AuditEventType = "impersonate"
+	// AuditRevoke is recorded when all the sessions of a user are revoked.
+	AuditRevoke AuditEventType = "revoke"
+)
+
+const (
+	// AuditSuccess is the outcome of an action that succeeded.
+	AuditSuccess = "success"
+	// AuditFailure is the outcome of an action that failed or was refused.
+	AuditFailure = "failure"
+)
+
+// AuditEvent is a security relevant event.
+type AuditEvent struct {
+	Type AuditEventType `json:"type"`
+	Time time.Time      `json:"time"`
+	// Subject is the subject of the user. For failures before the session was validated, it is
+	// taken from the session without verification, and may be empty.
+	Subject string `json:"subject,omitempty"`
+	// Target is the subject of the impersonated user, for impersonation events.
+	Target string `json:"target,omitempty"`
+	// Provider is the host of the OAuth2 provider.
+	Provider string `json:"provider"`
+	// Outcome is AuditSuccess or AuditFailure.
+	Outcome string `json:"outcome"`
+	// Reason describes failures and other details of the event.
+	Reason string `json:"reason,omitempty"`
+
+	// Request metadata.
+	RequestID  string `json:"requestId,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	UserAgent  string `json:"userAgent,omitempty"`
+	Path       string `json:"path,omitempty"`
+}
+
+// AuditSink records audit events. Record is called synchronously while serving the request,
+// therefore it should not block.
+type AuditSink interface {
+	Record(ctx context.Context, event AuditEvent)
+}
+
+// NopAuditSink discards all audit events. It is the default `Config.Audit`.
+type NopAuditSink struct{}
+
+// Record implements AuditSink.
+func (NopAuditSink) Record(context.Context, AuditEvent) {}
+
+// JSONAuditSink writes audit events to a writer as JSON lines. It is safe for concurrent use.
+type JSONAuditSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONAuditSink returns an audit sink that writes audit events to w.
+func NewJSONAuditSink(w io.Writer) *JSONAuditSink {
+	return &JSONAuditSink{enc: json.NewEncoder(w)}
+}
+
+// Record implements AuditSink.
+func (s *JSONAuditSink) Record(_ context.Context, event AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.enc.Encode(event)
+}
+
+// audit records an audit event of the request, with the request metadata.
+func (a *Auth) audit(r *http.Request, event AuditEvent) {
+	cfg := a.config()
+	if cfg.Audit == nil {
+		return
+	}
+	event.Time = a.now()
+	if u, err := url.Parse(cfg.Endpoint.AuthURL); err == nil {
+		event.Provider = u.Host
+	}
+	if event.Outcome == "" {
+		event.Outcome = AuditSuccess
+	}
+	event.RequestID = RequestID(r.Context())
+	event.RemoteAddr = r.RemoteAddr
+	event.UserAgent = r.UserAgent()
+	event.Path = r.URL.Path
+	cfg.Audit.Record(r.Context(), event)
+}
+
+// unverifiedSubject returns the subject of an ID token without verifying it.
+func unverifiedSubject(idToken string) string {
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	_ = decodeTokenSegment(idToken, 1, &claims)
+	return claims.Subject
+}
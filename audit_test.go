@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestAudit(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	a := &Auth{cfg: &Config{
+		Config:         oauth2.Config{Endpoint: oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth"}},
+		CanImpersonate: func(c *Creds) bool { return c.Email == "admin@example.com" },
+		Audit:          NewJSONAuditSink(&buf),
+		Log:            t.Logf,
+	}}
+
+	r := httptest.NewRequest(http.MethodGet, "/impersonate", nil)
+	r.Header.Set("User-Agent", "test")
+	r = r.WithContext(context.WithValue(r.Context(), credsKey, &Creds{Subject: "1", Email: "user@example.com"}))
+	r = withRequestID(httptest.NewRecorder(), r)
+
+	err := a.Impersonate(httptest.NewRecorder(), r, "2")
+	require.Error(t, err)
+
+	var event AuditEvent
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &event))
+	assert.Equal(t, AuditImpersonate, event.Type)
+	assert.Equal(t, "1", event.Subject)
+	assert.Equal(t, "2", event.Target)
+	assert.Equal(t, "accounts.example.com", event.Provider)
+	assert.Equal(t, AuditFailure, event.Outcome)
+	assert.Equal(t, RequestID(r.Context()), event.RequestID)
+	assert.Equal(t, "test", event.UserAgent)
+	assert.Equal(t, "/impersonate", event.Path)
+	assert.False(t, event.Time.IsZero())
+}
+
+func TestNopAuditSink(t *testing.T) {
+	t.Parallel()
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{ClientID: "client", RedirectURL: "http://localhost/auth"},
+		Log:    t.Logf,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, NopAuditSink{}, a.config().Audit)
+}
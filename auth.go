@@ -15,6 +15,9 @@
 //	mux.Handle("/auth", a.RedirectHandler())  // Handle OAuth2 redirect.
 //	log.Fatal(http.ListenAndServe(":8080", mux)) // Serve.
 //
+// The `Routes` method can be used to mount both the authenticated handler and the `RedirectHandler`
+// at once, such that they can't get out of sync.
+//
 // # Authentication
 //
 // Authentication is done by wrapping an `http.Handler` that requires only signed in users
@@ -55,10 +58,20 @@ package auth
 
 import (
 	"context"
+	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -67,13 +80,33 @@ import (
 )
 
 const (
-	afterKey   = "after"
-	cookieName = "login"
+	afterKey    = "after"
+	redirectKey = "redirect_uri"
+	cookieName  = "login"
+
+	// defaultMaxCookieBytes is the default maximum size of the session cookie.
+	defaultMaxCookieBytes = 4000
+
+	// maxClockSkew is the allowed difference between the provider clock and the local clock.
+	maxClockSkew = 5 * time.Minute
+
+	// maxErrorSnippet is the maximum number of body bytes that are kept from an unexpected
+	// provider response.
+	maxErrorSnippet = 512
 )
 
 type contextType string
 
-const credsKey contextType = "creds"
+const (
+	credsKey     contextType = "creds"
+	actorKey     contextType = "actor"
+	sessionKey   contextType = "session"
+	claimsKey    contextType = "claims"
+	cspNonceKey  contextType = "csp_nonce"
+	requestIDKey contextType = "request_id"
+	// accessReportKey holds the access checks of a dry run of Authenticate.
+	accessReportKey contextType = "access_report"
+)
 
 var defaultScopes = []string{
 	"https://www.googleapis.com/auth/userinfo.email",
@@ -95,43 +128,362 @@ type Config struct {
 	// console at: https://console.cloud.google.com/apis/credentials.
 	oauth2.Config
 
+	// RedirectURLFunc, if set, computes the OAuth2 redirect URL from the request instead of using
+	// the static RedirectURL. This is useful for ephemeral deployments, such as preview
+	// environments, that can't be registered in advance. The returned URL host must match one of
+	// RedirectDomains.
+	RedirectURLFunc func(r *http.Request) (string, error) `json:"-"`
+	// RedirectDomains are the allowed host patterns for URLs returned by RedirectURLFunc. A
+	// pattern can start with "*." to match any subdomain, e.g. "*.app.example.com".
+	RedirectDomains []string
+
+	// ExternalURL, if set, is the external base URL of the application, e.g.
+	// "https://app.example.com", for servers that can't derive it from the incoming requests, such
+	// as a sidecar that listens on a Unix domain socket behind a proxy. A RedirectURL that is only
+	// a path, e.g. "/auth", is resolved against it. Unless CookieSecure is set, cookies are secure
+	// when it has the https scheme.
+	ExternalURL string
+
+	// CanonicalCallback redirects callback requests that are received on another scheme or host
+	// than the redirect URL to the redirect URL scheme and host, before the code is exchanged.
+	// Otherwise, the session cookie is set on the wrong host, and the user loses the session
+	// after login. It should not be set when the scheme or host of the requests that the server
+	// receives are different than those of the browser, unless TrustForwardedHeaders is set and
+	// the proxy sets the forwarded headers.
+	CanonicalCallback bool
+	// TrustForwardedHeaders uses the X-Forwarded-Proto and X-Forwarded-Host headers as the
+	// request scheme and host. It should only be set behind a proxy that sets these headers.
+	TrustForwardedHeaders bool
+
+	// LoginRedirectStatus is the HTTP status of the redirect from `Authenticate` to the OAuth2 login
+	// flow. Defaults to http.StatusTemporaryRedirect.
+	LoginRedirectStatus int
+	// PostLoginRedirectStatus is the HTTP status of the redirect from `RedirectHandler` back to
+	// the application after a successful login. Defaults to http.StatusTemporaryRedirect. Posted
+	// callbacks, see FormPostCallback, are always redirected with http.StatusSeeOther, such that
+	// the browser doesn't post the code again to the application.
+	PostLoginRedirectStatus int
+
+	// AllowedIssuers, if set, allows ID tokens from each of the given issuers. The keys of each
+	// issuer are fetched using OIDC discovery, and tokens from other issuers are rejected. Google
+	// issuers are validated with Google's certificates. By default, only Google ID tokens are
+	// allowed.
+	AllowedIssuers []string
+	// DiscoveryCacheTTL is the maximum duration that the OIDC discovery document of an issuer is
+	// cached. The document is cached according to its Cache-Control header, up to this duration,
+	// and at least for a minute, also when the provider disallows caching. Defaults to 24 hours.
+	DiscoveryCacheTTL time.Duration
+	// JWKSCacheTTL is the maximum duration that the signing keys of an issuer are cached, same as
+	// DiscoveryCacheTTL. Keys are also fetched when a token is signed with an unknown key.
+	// Defaults to 1 hour.
+	JWKSCacheTTL time.Duration
+
+	// ClientPrivateKey, if set, authenticates the client at the token endpoint with the
+	// private_key_jwt method instead of the client secret: requests to the provider are sent
+	// with a short lived client assertion JWT that is signed with this key, with the client ID as
+	// the issuer and the subject, and the token endpoint URL as the audience. ClientKeyID is set
+	// as the "kid" header of the assertion. ClientSecret should not be set.
+	ClientPrivateKey *rsa.PrivateKey `json:"-"`
+	ClientKeyID      string
+
+	// TokenExchangeOptions, if set, returns additional parameters for the token request of the
+	// code exchange, that are not sent in the authorization URL. For example, a "resource" or an
+	// "audience" that the provider requires, or a client assertion using `ClientAssertion`.
+	TokenExchangeOptions func(r *http.Request) ([]oauth2.AuthCodeOption, error) `json:"-"`
+
+	// OnScopeDowngrade, if set, is called on login when the provider granted only some of the
+	// requested scopes, with the requested and the granted scopes. Returning an error denies the
+	// login. Otherwise, the session is created with the granted scopes, which are available using
+	// `Session`.
+	OnScopeDowngrade func(requested, granted []string) error `json:"-"`
+
+	// RequestClaims is the OIDC "claims" request parameter, for providers that return some claims
+	// only when they are explicitly requested. For example:
+	//
+	//	{"id_token": {"email": {"essential": true}}}
+	//
+	// A warning is logged when a requested ID token claim is missing after login.
+	RequestClaims json.RawMessage
+
+	// UsePAR sends the authorization request parameters to the provider's pushed authorization
+	// request endpoint (RFC 9126), and redirects the user to the login with the returned
+	// request_uri instead of the parameters. The endpoint is PAREndpoint if set, or otherwise it is
+	// discovered using the OIDC discovery of the first of AllowedIssuers.
+	UsePAR      bool
+	PAREndpoint string
+
+	// BareCallbackBehavior is the response of `RedirectHandler` to requests without the OAuth2
+	// callback parameters, for example when users bookmark the callback URL, and to callbacks with
+	// a code that was already used, for example when users refresh the callback page. Defaults to
+	// BareCallbackError.
+	BareCallbackBehavior BareCallbackBehavior
+
+	// FormPostCallback requests the provider to post the authorization response to the redirect
+	// URL (response_mode=form_post), instead of passing it in the URL query. The posted callback
+	// is protected by a CSRF token in the state, that must match a cookie that is set when the
+	// login starts. The cookie is SameSite=None, therefore secure cookies are required.
+	FormPostCallback bool
+
+	// StrictMode fails closed on any ambiguity in the ID token. When set, the following checks are
+	// enforced in addition to the standard signature, audience and expiry validation:
+	//
+	// - The token is signed with RS256.
+	// - The "email" and "name" claims are present.
+	// - The "email_verified" claim is true.
+	// - The "azp" claim is present and equals the client ID.
+	// - The "iat" claim is present and is not in the future.
+	// - The "at_hash" claim is present and matches the access token, when an access token exists.
+	//
+	// Nonce validation is not included since the authentication flow does not send a nonce.
+	StrictMode bool
+
+	// DetectRefreshReuse revokes all the sessions of a user when a refresh token that was already
+	// rotated is presented again. With refresh token rotation, every refresh replaces the refresh
+	// token of the session, therefore an old refresh token that is presented again indicates that
+	// the session cookie may have been stolen and used in parallel to the user. The rotated
+	// refresh tokens are remembered in memory for a day, as hashes. A rotated refresh token that
+	// is presented within a minute of its rotation is a concurrent request of the same session,
+	// and is not treated as reuse. Other "invalid_grant" errors, such as a revoked consent or an
+	// expired refresh token, only end the session. Sessions are revoked in memory, as with
+	// back-channel logouts, and a security event is logged. The user is identified by the
+	// signature of the session ID token, even if it expired.
+	DetectRefreshReuse bool
+
+	// UserKeyClaim is the ID token claim that identifies users, for example "email", "oid" or a
+	// custom claim. Its value is available as `Creds.Key`, and it is used to track users by the
+	// package. Logins with ID tokens that don't have this claim fail. By default, users are
+	// identified by the "sub" claim.
+	UserKeyClaim string
+
+	// DisplayNameFunc returns the display name of a user, that is set to `Creds.Name`. It is
+	// called with the credentials from the ID token claims. Defaults to `DefaultDisplayName`.
+	DisplayNameFunc func(*Creds) string `json:"-"`
+
+	// IsBot, if set, identifies requests of bots and crawlers. Such requests that are not
+	// authenticated get an unauthorized response instead of a redirect to the OAuth2 login flow.
+	// `IsCrawler` can be used for a user agent based detection.
+	IsBot func(r *http.Request) bool `json:"-"`
+
+	// APIRequest identifies requests of API clients. Such requests that are not authenticated get
+	// a machine readable unauthorized response, written by LoginRequired, instead of a redirect
+	// to the OAuth2 login flow. Defaults to `IsAPIRequest`, that keeps browser navigation
+	// redirecting.
+	APIRequest func(r *http.Request) bool `json:"-"`
+	// LoginRequired writes the unauthorized response to API requests. Defaults to
+	// `ProblemLoginRequired`.
+	LoginRequired func(w http.ResponseWriter, r *http.Request) `json:"-"`
+
+	// Require2SV denies users that are not enrolled in 2-step verification in Google Workspace. The
+	// enrollment is checked using the Directory API with DirectoryClient, and is cached per user.
+	Require2SV bool
+	// DirectoryClient is an http client that is authorized for the Directory API with the
+	// "https://www.googleapis.com/auth/admin.directory.user.readonly" scope. Usually, this is
+	// a service account client with domain-wide delegation, that impersonates a Workspace admin.
+	// Required when Require2SV is set.
+	DirectoryClient *http.Client `json:"-"`
+
+	// CanImpersonate, if set, returns whether a user is allowed to impersonate other users using
+	// `Impersonate`.
+	CanImpersonate func(*Creds) bool `json:"-"`
+	// CanDebugAccess, if set, allows users to use `Auth.CanAccessHandler`, that exposes the
+	// access checks of their session.
+	CanDebugAccess func(*Creds) bool `json:"-"`
+
+	// DenialThreshold, if set, is the number of times that an authenticated user can be denied by
+	// authorization within DenialCooldown, before login attempts of this user get a forbidden
+	// response without going through the OAuth2 flow, for the rest of the DenialCooldown. This
+	// stops users that are not allowed from looping through the OAuth2 flow.
+	DenialThreshold int
+	// DenialCooldown defaults to 15 minutes.
+	DenialCooldown time.Duration
+
+	// IssueIdentityJWT, if set, issues a short lived signed JWT of the user identity on
+	// authenticated responses. See `IdentityJWT`.
+	IssueIdentityJWT *IdentityJWT `json:"-"`
+
+	// DenyAll is the initial deny all mode, that can be changed with `Auth.SetDenyAll`. It is
+	// ignored on reload.
+	DenyAll bool
+	// DenyAllDropSessions makes the deny all mode also clear existing sessions, instead of
+	// honoring them.
+	DenyAllDropSessions bool
+	// MaintenanceHandler, if set, writes the response to requests that are denied by the deny all
+	// mode. Defaults to a 503 service unavailable response.
+	MaintenanceHandler http.Handler `json:"-"`
+
+	// ForwardHeaders maps ID token claims to the names of the headers that `VerifyHandler` sets
+	// with their values, for example {"email": "X-Forwarded-Email", "sub": "X-User-Id"}. Defaults
+	// to {"sub": "X-Auth-Subject", "email": "X-Auth-Email"}.
+	ForwardHeaders map[string]string
+
+	// Audit records the security relevant events, such as logins, logouts and denials. Defaults
+	// to `NopAuditSink`. See `NewJSONAuditSink`.
+	Audit AuditSink `json:"-"`
+
 	// Disable authentication.
 	Disable bool
 
 	Log    func(string, ...interface{}) `json:"-"`
 	Client *http.Client                 `json:"-"`
+	// MaxRetryAfter is the maximum duration to wait before retrying a request that was rate
+	// limited by the provider, according to its Retry-After header. Longer waits, or waits that
+	// exceed the request deadline, fail the request with a "provider rate limited" error, and
+	// following requests to the provider fail without being sent until the Retry-After time.
+	// Defaults to 0, which never waits.
+	MaxRetryAfter time.Duration
+	// Clock returns the current time. It is used for cookie and token expiry, caches, cooldowns
+	// and revocations, such that time dependent behavior can be tested without waiting. Defaults
+	// to `time.Now`. Rate limit waits use the real time, and ID tokens validated by the Google
+	// validator are also checked for expiry against the real time.
+	Clock func() time.Time `json:"-"`
+
+	// StoreAccessToken, StoreRefreshToken and StoreIDToken control which tokens are stored in the
+	// session cookie. All default to true. Without a stored refresh token, the user needs to log in
+	// again when the session expires. The ID token is required to authenticate the session,
+	// therefore StoreIDToken can't be false.
+	StoreAccessToken  *bool
+	StoreRefreshToken *bool
+	StoreIDToken      *bool
+
+	// AvatarHosts are the hosts that `AvatarHandler` fetches profile pictures from. An entry that
+	// starts with "*." matches the subdomains of the domain that follows. The picture URL comes
+	// from the ID token, therefore other hosts are refused, such that an issuer can't make the
+	// server fetch internal URLs. Only https URLs are fetched, and redirects to other hosts are
+	// refused. Defaults to Google's "*.googleusercontent.com".
+	AvatarHosts []string
+
+	// PublicCookie, if set, is the name of an additional cookie that is readable by JavaScript and
+	// holds the public user fields, the name and the picture, as base64url encoded JSON. It saves
+	// single page applications a request for displaying the user. The session cookie itself is
+	// not readable by JavaScript. The public cookie is not authoritative and must not be used for
+	// authorization.
+	PublicCookie string
+
+	// ExpiresAtHeader sets the "Expires-At" header on authenticated responses, with the time that
+	// the session needs to be renewed. See `SessionInfo`.
+	ExpiresAtHeader bool
+
+	// ValidationCacheSize, if set, is the number of validated ID tokens that are cached, keyed by
+	// the token hash, such that repeated requests of the same session skip the token validation
+	// until the token expires. It can't be changed on reload.
+	ValidationCacheSize int
+
+	// CSPNonce generates a random nonce for every request that goes through the authentication
+	// handlers, and sets a baseline Content-Security-Policy header that allows only scripts and
+	// styles with this nonce. The nonce is available to the application using `CSPNonce`, and
+	// the application handlers can override the header with their own policy.
+	CSPNonce bool
+
+	// LogoutRequirePOST protects the logout from cross site request forgery, by allowing logout
+	// only with POST requests that have a CSRF token. GET requests to the logout handler get a
+	// confirmation form. See `Auth.LogoutHandler`.
+	LogoutRequirePOST bool
+
+	// MaxCookieBytes is the maximum size of the session cookie. Browsers silently drop cookies
+	// that are larger than about 4KB, therefore login fails with a descriptive error when the
+	// session cookie exceeds this size. Defaults to 4000.
+	MaxCookieBytes int
 
 	// set cookie's path
 	Path string
-	// Unsecure uses unsecured cookies (Required for http scheme).
+	// Unsecure marks the deployment as served over the http scheme. Unless CookieSecure is set, it
+	// also uses unsecured cookies (Required for http scheme).
 	Unsecure bool
+	// CookieSecure sets the cookie Secure flag independently of Unsecure, for example to require
+	// secure cookies for an http server behind a TLS terminating proxy. Defaults to !Unsecure, or
+	// to whether ExternalURL has the https scheme when it is set.
+	CookieSecure *bool
+	// StrictSameSiteBootstrap sets the session cookie with SameSite=Strict, for the best CSRF
+	// protection. Since browsers don't send Strict cookies on the navigation that comes back from
+	// the provider, a short lived SameSite=Lax bootstrap cookie is set on login, and used when the
+	// session cookie is not sent. Note that with Strict cookies, navigations from other sites to
+	// the application are not logged in, and go through the login flow.
+	StrictSameSiteBootstrap bool
 }
 
 // Auth is an authentication handler.
 type Auth struct {
 	validator *idtoken.Validator
-	cfg       Config
+	// cfg is the current configuration. It is replaced on Reload and should be accessed using
+	// the config method.
+	cfg *Config
+	mu  sync.RWMutex
+	// client is used for calls to the OAuth2 provider token endpoint.
+	client *http.Client
+	// validations caches ID token validation results. It is nil if ValidationCacheSize is not set.
+	validations *validationCache
+	// discoveries caches the OIDC discovery documents of issuers.
+	discoveries   map[string]*discoveryEntry
+	discoveriesMu sync.Mutex
+	// keySets caches the signing keys of AllowedIssuers.
+	keySets   map[string]*keySetEntry
+	keySetsMu sync.Mutex
+	// discovery deduplicates concurrent fetches of the provider metadata.
+	discovery flightGroup
+	// enrollments caches the 2-step verification enrollment of users.
+	enrollments   map[string]enrollment
+	enrollmentsMu sync.Mutex
+	// avatars caches the profile pictures that are served by AvatarHandler, by URL.
+	avatars   map[string]*avatar
+	avatarsMu sync.Mutex
+	// denials tracks the authorization denials of users, by user key.
+	denials   map[string]*denial
+	denialsMu sync.Mutex
+	// revocations maps subjects that were logged out by the provider to the logout time.
+	revocations map[string]time.Time
+	// sessionRevocations maps provider session IDs that were logged out by the provider to the
+	// logout time.
+	sessionRevocations map[string]time.Time
+	revocationsMu      sync.Mutex
+	// rotatedRefreshTokens maps hashes of refresh tokens that were rotated to the rotation time.
+	// It is only set when DetectRefreshReuse is set.
+	rotatedRefreshTokens   map[string]time.Time
+	rotatedRefreshTokensMu sync.Mutex
+	// refreshes deduplicates concurrent refreshes of the same refresh token.
+	refreshes flightGroup
+	// rateLimits maps provider hosts that rate limited the requests to the Retry-After time.
+	rateLimits   map[string]time.Time
+	rateLimitsMu sync.Mutex
+	// denyAll is set to 1 while the deny all mode is on.
+	denyAll int32
+	// loginPath is the path of the LoginHandler when it was mounted using AuthRoutes.
+	loginPath atomic.Value
+	// hasRedirectHandler is set to 1 once the RedirectHandler was created.
+	hasRedirectHandler int32
+	// warnRedirectHandler is used to log missing RedirectHandler only once.
+	warnRedirectHandler sync.Once
 }
 
 // Creds is the credentials of the logged in user.
 type Creds struct {
+	// Subject is the provider's stable identifier of the user.
+	Subject string
+	// Key is the stable identifier of the user that is used by the application, the value of the
+	// Config.UserKeyClaim claim. By default, it is the Subject.
+	Key string
 	// Email of user. Can be used to identify the user.
 	Email string
 	// Name of user. User may change the name, therefore this field should not be used for
-	// authentication.
+	// authentication. It is set by `Config.DisplayNameFunc`, and it is never empty.
 	Name string
+	// GivenName and FamilyName of the user, if provided.
+	GivenName  string
+	FamilyName string
+	// Picture is the URL of the user profile picture.
+	Picture string
 }
 
 // New returns an authentication handler.
 func New(ctx context.Context, cfg Config) (*Auth, error) {
 	if cfg.Disable {
-		a := &Auth{cfg: cfg}
+		a := &Auth{cfg: &cfg}
 		a.logf("Authentication is disabled!")
 		return a, nil
 	}
 
-	if cfg.Client == nil {
-		cfg.Client = http.DefaultClient
+	err := cfg.applyDefaults()
+	if err != nil {
+		return nil, err
 	}
 
 	tokenValidator, err := idtoken.NewValidator(ctx, idtoken.WithHTTPClient(cfg.Client))
@@ -139,115 +491,673 @@ func New(ctx context.Context, cfg Config) (*Auth, error) {
 		return nil, err
 	}
 
-	// Apply default values.
+	a := &Auth{validator: tokenValidator, cfg: &cfg}
+	base := cfg.Client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	a.client = &http.Client{
+		Transport:     &providerTransport{base: &rateLimitTransport{base: &clientAssertionTransport{base: base, a: a}, a: a}},
+		CheckRedirect: cfg.Client.CheckRedirect,
+		Jar:           cfg.Client.Jar,
+		Timeout:       cfg.Client.Timeout,
+	}
+	if cfg.DenyAll {
+		a.denyAll = 1
+	}
+	if cfg.ValidationCacheSize > 0 {
+		a.validations = newValidationCache(cfg.ValidationCacheSize)
+	}
+	return a, nil
+}
+
+// Reload replaces the configuration without dropping existing sessions. It can be used to update
+// policy fields, such as the scopes or the redirect behavior, without a restart. Fields that
+// would invalidate existing sessions or require a new provider client - the client credentials,
+// the endpoint, the client, ValidationCacheSize and Disable - can't be changed and result in an error.
+func (a *Auth) Reload(cfg Config) error {
+	old := a.config()
+	if cfg.Disable != old.Disable {
+		return fmt.Errorf("Disable can't be changed on reload")
+	}
+	if cfg.Disable {
+		a.setConfig(&cfg)
+		return nil
+	}
+
+	if cfg.Client == nil {
+		cfg.Client = old.Client
+	}
+	err := cfg.applyDefaults()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case cfg.ClientID != old.ClientID || cfg.ClientSecret != old.ClientSecret:
+		return fmt.Errorf("client credentials can't be changed on reload")
+	case cfg.Endpoint != old.Endpoint:
+		return fmt.Errorf("endpoint can't be changed on reload")
+	case cfg.Client != old.Client:
+		return fmt.Errorf("client can't be changed on reload")
+	case cfg.ValidationCacheSize != old.ValidationCacheSize:
+		return fmt.Errorf("ValidationCacheSize can't be changed on reload")
+	}
+
+	a.setConfig(&cfg)
+	if a.validations != nil {
+		// Cached validations may not hold under the new configuration, e.g. the allowed issuers.
+		a.validations.purge()
+	}
+	a.logf("Configuration reloaded")
+	return nil
+}
+
+// applyDefaults validates the configuration and sets the default values of unset fields.
+func (cfg *Config) applyDefaults() error {
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if cfg.RedirectURLFunc != nil && len(cfg.RedirectDomains) == 0 {
+		return fmt.Errorf("RedirectURLFunc requires RedirectDomains")
+	}
+	if !boolValue(cfg.StoreIDToken, true) {
+		return fmt.Errorf("StoreIDToken can't be false: the ID token is required to authenticate sessions")
+	}
+	if cfg.FormPostCallback && !cfg.cookieSecure() {
+		return fmt.Errorf("FormPostCallback requires secure cookies")
+	}
+	if cfg.UsePAR && cfg.PAREndpoint == "" && len(cfg.AllowedIssuers) == 0 {
+		return fmt.Errorf("UsePAR requires PAREndpoint or AllowedIssuers")
+	}
+	if cfg.ClientPrivateKey != nil {
+		if cfg.ClientSecret != "" {
+			return fmt.Errorf("ClientPrivateKey can't be used with ClientSecret")
+		}
+		err := cfg.ClientPrivateKey.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid ClientPrivateKey: %v", err)
+		}
+	}
+	if cfg.Require2SV && cfg.DirectoryClient == nil {
+		return fmt.Errorf("Require2SV requires a DirectoryClient")
+	}
+	if cfg.IssueIdentityJWT != nil && cfg.IssueIdentityJWT.Key == nil {
+		return fmt.Errorf("IssueIdentityJWT requires a Key")
+	}
+	if len(cfg.RequestClaims) > 0 {
+		_, err := requestedIDTokenClaims(cfg.RequestClaims)
+		if err != nil {
+			return fmt.Errorf("invalid RequestClaims: %v", err)
+		}
+	}
+	if cfg.ExternalURL != "" {
+		err := cfg.resolveRedirectURL()
+		if err != nil {
+			return err
+		}
+	}
 	if cfg.Endpoint.AuthURL == "" || cfg.Endpoint.TokenURL == "" {
 		cfg.Endpoint = google.Endpoint
 	}
+	if cfg.ClientPrivateKey != nil {
+		// The client is authenticated by the client assertion in the request parameters.
+		cfg.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
 	if len(cfg.Scopes) == 0 {
 		cfg.Scopes = defaultScopes
 	}
+	scopeWarnings, err := checkScopes(cfg.Scopes, cfg.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid Scopes: %v", err)
+	}
+	for _, warning := range scopeWarnings {
+		if cfg.Log != nil {
+			cfg.Log("Warning: %s", warning)
+		}
+	}
+	if cfg.DisplayNameFunc == nil {
+		cfg.DisplayNameFunc = DefaultDisplayName
+	}
+	if cfg.Audit == nil {
+		cfg.Audit = NopAuditSink{}
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = time.Now
+	}
+	if cfg.APIRequest == nil {
+		cfg.APIRequest = IsAPIRequest
+	}
+	if cfg.LoginRequired == nil {
+		cfg.LoginRequired = ProblemLoginRequired
+	}
+	if cfg.MaxCookieBytes == 0 {
+		cfg.MaxCookieBytes = defaultMaxCookieBytes
+	}
+	if cfg.LoginRedirectStatus == 0 {
+		cfg.LoginRedirectStatus = http.StatusTemporaryRedirect
+	}
+	if cfg.PostLoginRedirectStatus == 0 {
+		cfg.PostLoginRedirectStatus = http.StatusTemporaryRedirect
+	}
+	if !isRedirect(cfg.LoginRedirectStatus) || !isRedirect(cfg.PostLoginRedirectStatus) {
+		return fmt.Errorf("invalid redirect status: login %d, post login %d",
+			cfg.LoginRedirectStatus, cfg.PostLoginRedirectStatus)
+	}
+	return nil
+}
 
-	return &Auth{validator: tokenValidator, cfg: cfg}, nil
+// resolveRedirectURL validates ExternalURL and resolves a RedirectURL path against it.
+func (cfg *Config) resolveRedirectURL() error {
+	base, err := url.Parse(cfg.ExternalURL)
+	if err != nil {
+		return fmt.Errorf("invalid ExternalURL %q: %v", cfg.ExternalURL, err)
+	}
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return fmt.Errorf("ExternalURL %q should be an absolute http or https URL", cfg.ExternalURL)
+	}
+	if strings.HasPrefix(cfg.RedirectURL, "/") {
+		cfg.RedirectURL = strings.TrimSuffix(base.String(), "/") + cfg.RedirectURL
+	}
+	return nil
+}
+
+// cookieSecure returns whether cookies should have the Secure flag.
+func (cfg *Config) cookieSecure() bool {
+	secure := !cfg.Unsecure
+	if cfg.ExternalURL != "" {
+		secure = strings.HasPrefix(cfg.ExternalURL, "https://")
+	}
+	return boolValue(cfg.CookieSecure, secure)
+}
+
+// config returns the current configuration. The returned configuration must not be modified.
+func (a *Auth) config() *Config {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.cfg
 }
 
-// Authenticate wraps a handler and enforces only authenticated users.
-func (a *Auth) Authenticate(handler http.Handler) http.Handler {
+// now returns the current time of the configured clock.
+func (a *Auth) now() time.Time {
+	cfg := a.config()
+	if cfg == nil || cfg.Clock == nil {
+		return time.Now()
+	}
+	return cfg.Clock()
+}
+
+func (a *Auth) setConfig(cfg *Config) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cfg = cfg
+}
+
+// Option configures the policy of a handler mounted with `Authenticate`.
+type Option func(*options)
+
+type options struct {
+	authorize func(*Creds) bool
+	path      string
+	// dryRun reports the access checks in the request context instead of denying access.
+	dryRun bool
+	// forward handles forward authentication requests, see `Auth.VerifyHandler`.
+	forward bool
+}
+
+// WithAuthorize allows only users for which the given function returns true. Other authenticated
+// users get a forbidden response.
+func WithAuthorize(authorize func(*Creds) bool) Option {
+	return func(o *options) { o.authorize = authorize }
+}
+
+// WithPath sets the path that users are redirected to after login. By default, users are
+// redirected back to the path that requested the authentication.
+func WithPath(path string) Option {
+	return func(o *options) { o.path = path }
+}
+
+// Authenticate wraps a handler and enforces only authenticated users. Options can be given to
+// apply a different policy for each mounted handler.
+func (a *Auth) Authenticate(handler http.Handler, opts ...Option) http.Handler {
 	if handler == nil {
 		panic("auth: nil handler")
 	}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if a.cfg.Disable {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := a.config()
+		if cfg.Disable {
 			handler.ServeHTTP(w, r)
 			return
 		}
 
+		// The path to return to after login. By default, it is the current request URL, it will be
+		// used by the redirect handler to redirect back to the url that requested the authentication.
+		redirectPath := r.RequestURI
+		if o.forward {
+			redirectPath = forwardedURI(r)
+		}
+		if o.path != "" {
+			redirectPath = o.path
+		}
+		requireLogin := a.requireLogin
+		if o.forward {
+			requireLogin = a.forwardRequireLogin
+		}
+
 		token, err := a.getCookie(r)
+		if a.deniesAll() && (token == nil || err != nil || cfg.DenyAllDropSessions) {
+			if token != nil || err != nil {
+				a.clearCookie(w)
+			}
+			a.logr(r.Context(), "Request denied by the deny all mode")
+			a.maintenance(w, r)
+			return
+		}
 		if token == nil && err == nil {
 			// Cookie is missing, invalid. Fetch new token from OAuth2 provider.
-			// Redirect user to the OAuth2 consent page to ask for permission for the scopes specified
-			// above.
-			// Set the scope to the current request URL, it will be used by the redirect handler to
-			// redirect back to the url that requested the authentication.
-			opts := []oauth2.AuthCodeOption{oauth2.ApprovalForce, oauth2.AccessTypeOffline}
-			url := a.cfg.AuthCodeURL(r.RequestURI, opts...)
-			http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+			requireLogin(w, r, redirectPath)
 			return
 		}
 		if err != nil {
 			a.clearCookie(w)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			a.logf("Get cookie error: %v", err)
+			a.logr(r.Context(), "Get cookie error: %v", err)
 			return
 		}
 
-		// Source token, in case the token needs a renewal.
-		newOauth2Token, err := a.cfg.TokenSource(r.Context(), token.toOauth2()).Token()
-		if err != nil {
-			a.clearCookie(w)
-			http.Error(w, "Internal error", http.StatusInternalServerError)
-			a.logf("Failed token source: %s", err)
-			return
-		}
-		newToken := fromOauth2(newOauth2Token)
+		// Source token, in case the token needs a renewal. When the access token is not stored, the
+		// token is only renewed after it expired.
+		expired := !token.Expiry.IsZero() && a.now().After(token.Expiry)
+		if token.AccessToken != "" || expired {
+			if expired && token.RefreshToken == "" {
+				// The token can't be renewed, the user needs to login again.
+				a.clearCookie(w)
+				a.logr(r.Context(), "Session expired and can't be renewed without a refresh token")
+				requireLogin(w, r, redirectPath)
+				return
+			}
+			newOauth2Token, err := a.refresh(r, token)
+			if err != nil && isInvalidGrant(err) {
+				a.refreshTokenRejected(r, token)
+			}
+			if err != nil {
+				a.audit(r, AuditEvent{Type: AuditRefresh, Subject: unverifiedSubject(token.IDToken), Outcome: AuditFailure, Reason: err.Error()})
+				if wait, ok := rateLimitError(err); ok {
+					// The session is kept, the renewal can be retried later.
+					providerRateLimited(w, wait)
+					a.logr(r.Context(), "Token renewal was rate limited by the provider, retry after %s", wait)
+					return
+				}
+				a.clearCookie(w)
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				a.logr(r.Context(), "Failed token source: %s", err)
+				return
+			}
+			err = checkTokenType(newOauth2Token)
+			if err != nil {
+				a.audit(r, AuditEvent{Type: AuditRefresh, Subject: unverifiedSubject(token.IDToken), Outcome: AuditFailure, Reason: err.Error()})
+				a.clearCookie(w)
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				a.logr(r.Context(), "Invalid refreshed token: %s", err)
+				return
+			}
+			newToken := fromOauth2(newOauth2Token)
+			newToken.LoginAt = token.LoginAt
+			if newToken.SessionID = tokenSessionID(newToken.IDToken); newToken.SessionID == "" {
+				newToken.SessionID = token.SessionID
+			}
+			newToken.GrantedScopes = token.GrantedScopes
 
-		if newToken.IDToken != token.IDToken {
-			a.logf("Refreshed token")
-			token = newToken
-			a.setCookie(w, token)
+			if newToken.IDToken != token.IDToken {
+				a.logr(r.Context(), "Refreshed token")
+				a.audit(r, AuditEvent{Type: AuditRefresh, Subject: unverifiedSubject(newToken.IDToken)})
+				token = newToken
+				err = a.setCookie(w, token)
+				if err != nil {
+					a.logr(r.Context(), "Failed setting refreshed token cookie: %v", err)
+				}
+			}
 		}
 
 		// Validate the id_token.
-		payload, err := a.validator.Validate(r.Context(), token.IDToken, a.cfg.ClientID)
+		payload, err := a.validateCached(r, token.IDToken)
 		if err != nil {
 			a.clearCookie(w)
 			http.Error(w, "Invalid auth.", http.StatusUnauthorized)
-			a.logf("Invalid token, reset cookie: %s", err)
+			a.logr(r.Context(), "Invalid token, reset cookie: %s", err)
+			return
+		}
+		if cfg.StrictMode {
+			err = a.verifyStrict(payload, token)
+			if err != nil {
+				a.clearCookie(w)
+				http.Error(w, "Invalid auth.", http.StatusUnauthorized)
+				a.logr(r.Context(), "Strict verification failed, reset cookie: %s", err)
+				return
+			}
+		}
+		sessionID, _ := payload.Claims["sid"].(string)
+		if sessionID == "" {
+			sessionID = token.SessionID
+		}
+		if a.revoked(payload.Subject, sessionID, token.LoginAt) {
+			a.clearCookie(w)
+			http.Error(w, "Invalid auth.", http.StatusUnauthorized)
+			a.logr(r.Context(), "Session was logged out by the provider, reset cookie")
 			return
 		}
 		// User is authenticated.
 		// Store email and name in context, and call the inner handler.
+		email, _ := payload.Claims["email"].(string)
+		name, _ := payload.Claims["name"].(string)
+		givenName, _ := payload.Claims["given_name"].(string)
+		familyName, _ := payload.Claims["family_name"].(string)
+		picture, _ := payload.Claims["picture"].(string)
+		key := payload.Subject
+		if cfg.UserKeyClaim != "" {
+			key, err = userKey(payload.Claims, cfg.UserKeyClaim)
+			if err != nil {
+				a.clearCookie(w)
+				http.Error(w, "Invalid auth.", http.StatusUnauthorized)
+				a.logr(r.Context(), "Invalid user key, reset cookie: %s", err)
+				return
+			}
+		}
 		creds := &Creds{
-			Email: payload.Claims["email"].(string),
-			Name:  payload.Claims["name"].(string),
+			Subject:    payload.Subject,
+			Key:        key,
+			Email:      email,
+			Name:       name,
+			GivenName:  givenName,
+			FamilyName: familyName,
+			Picture:    picture,
+		}
+		creds.Name = cfg.DisplayNameFunc(creds)
+		var report *accessReport
+		if o.dryRun {
+			report = &accessReport{}
+		}
+		if cfg.Require2SV {
+			enrolled, err := a.enrolledIn2SV(r.Context(), payload.Subject)
+			if err != nil {
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				a.logr(r.Context(), "Failed checking 2-step verification of %q: %v", creds.Email, err)
+				return
+			}
+			report.add("2sv", enrolled, "Require2SV: user must be enrolled in 2-step verification")
+			if !enrolled && report == nil {
+				http.Error(w, "2-Step Verification is required", http.StatusForbidden)
+				a.logr(r.Context(), "User %q is not enrolled in 2-step verification", creds.Email)
+				a.audit(r, AuditEvent{Type: AuditDenied, Subject: creds.Subject, Outcome: AuditFailure, Reason: "not enrolled in 2-step verification"})
+				return
+			}
+		}
+		ctx := r.Context()
+		claims := payload.Claims
+		if target := a.impersonationTarget(w, r, creds); target != nil {
+			ctx = context.WithValue(ctx, actorKey, creds)
+			creds = target
+			claims = map[string]interface{}{"sub": target.Subject}
+		}
+		if report != nil {
+			if o.authorize != nil {
+				report.add("authorize", o.authorize(creds), "WithAuthorize: user must pass the authorize function")
+			}
+			if cfg.DenialThreshold > 0 {
+				report.add("cooldown", !a.coolingDown(creds.Key), "DenialThreshold: user must not be in a denial cooldown")
+			}
+			ctx = context.WithValue(ctx, accessReportKey, report)
+		} else if o.authorize != nil && !o.authorize(creds) {
+			a.recordDenial(w, r, creds)
+			a.audit(r, AuditEvent{Type: AuditDenied, Subject: creds.Subject, Outcome: AuditFailure, Reason: "not authorized"})
+			a.forbidden(w, r)
+			a.logr(r.Context(), "User %q is not authorized for %s", creds.Email, r.URL.Path)
+			return
+		}
+		if cfg.PublicCookie != "" {
+			a.setPublicCookie(w, r, creds)
+		}
+		if cfg.IssueIdentityJWT != nil {
+			err = a.issueIdentity(w, r, creds)
+			if err != nil {
+				a.logr(r.Context(), "Failed issuing identity JWT: %v", err)
+			}
+		}
+		session := &SessionInfo{ExpiresAt: time.Unix(payload.Expires, 0), Scopes: token.GrantedScopes}
+		if session.Scopes == nil {
+			session.Scopes = cfg.Scopes
+		}
+		if token.LoginAt != 0 {
+			session.LoginAt = time.Unix(token.LoginAt, 0)
+		}
+		if cfg.ExpiresAtHeader {
+			w.Header().Set("Expires-At", session.ExpiresAt.UTC().Format(http.TimeFormat))
 		}
-		r = r.WithContext(context.WithValue(r.Context(), credsKey, creds))
+		ctx = context.WithValue(ctx, sessionKey, session)
+		ctx = context.WithValue(ctx, claimsKey, claims)
+		r = r.WithContext(context.WithValue(ctx, credsKey, creds))
 		handler.ServeHTTP(w, r)
-	})
+	}))
 }
 
 // RedirectHandler should be mounted on the cfg.OAuth2.RedirectURL path.
 func (a *Auth) RedirectHandler() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		code := r.URL.Query().Get("code")
-		token, err := a.cfg.Exchange(r.Context(), code)
+	atomic.StoreInt32(&a.hasRedirectHandler, 1)
+	return a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.deniesAll() {
+			a.logr(r.Context(), "Login refused by the deny all mode")
+			a.maintenance(w, r)
+			return
+		}
+		if bareCallback(r) {
+			a.bareCallback(w, r, "")
+			return
+		}
+		params, err := a.callbackParams(w, r)
+		if err != nil {
+			a.logr(r.Context(), "Invalid callback: %s", err)
+			a.audit(r, AuditEvent{Type: AuditLogin, Outcome: AuditFailure, Reason: err.Error()})
+			http.Error(w, "Invalid callback", http.StatusForbidden)
+			return
+		}
+		code := params.Get("code")
+		redirectPath, redirectURL, err := a.parseState(params.Get("state"))
+		if err != nil {
+			a.logr(r.Context(), "Invalid state: %s", err)
+			http.Error(w, "Invalid state", http.StatusBadRequest)
+			return
+		}
+		if cfg := a.config(); cfg.CanonicalCallback {
+			if canonical, ok := canonicalCallback(r, redirectURL, cfg.TrustForwardedHeaders); ok {
+				// The login cookie should be set on the host of the redirect URL, the code was not
+				// exchanged yet and can be used on the canonical host.
+				a.logr(r.Context(), "Callback was received on another scheme or host than the redirect "+
+					"URL %q, redirecting to %q", redirectURL, canonical)
+				http.Redirect(w, r, canonical, http.StatusTemporaryRedirect)
+				return
+			}
+		}
+		if providerErr := authorizationError(params); providerErr != nil {
+			a.logr(r.Context(), "Authorization failure: %s", providerErr)
+			a.audit(r, AuditEvent{Type: AuditLogin, Outcome: AuditFailure, Reason: providerErr.Error()})
+			a.loginFailed(w, r, providerErr)
+			return
+		}
+		var exchangeOpts []oauth2.AuthCodeOption
+		if tokenExchangeOptions := a.config().TokenExchangeOptions; tokenExchangeOptions != nil {
+			exchangeOpts, err = tokenExchangeOptions(r)
+			if err != nil {
+				a.logr(r.Context(), "Failed getting token exchange options: %s", err)
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+		}
+		token, err := a.oauth2Config(redirectURL).Exchange(a.providerContext(r.Context()), code, exchangeOpts...)
+		if err != nil {
+			a.logr(r.Context(), "Authentication failure for code %s: %s", code, err)
+			a.audit(r, AuditEvent{Type: AuditLogin, Outcome: AuditFailure, Reason: err.Error()})
+			if wait, ok := rateLimitError(err); ok {
+				w.Header().Set("Retry-After", retryAfterValue(wait))
+			}
+			if isInvalidGrant(err) && a.config().BareCallbackBehavior != BareCallbackError {
+				a.bareCallback(w, r, redirectPath)
+				return
+			}
+			a.loginFailed(w, r, tokenError(err))
+			return
+		}
+		err = checkTokenType(token)
 		if err != nil {
-			a.logf("Authentication failure for code %s: %s", code, err)
+			a.logr(r.Context(), "Invalid token for code %s: %s", code, err)
 			http.Error(w, "Authorization failure", http.StatusUnauthorized)
 			return
 		}
 
-		_, ok := token.Extra("id_token").(string)
+		idToken, ok := token.Extra("id_token").(string)
 		if !ok {
-			a.logf("Invalid ID token %v (%T)", token.Extra("id_token"), token.Extra("id_token"))
+			a.logr(r.Context(), "Invalid ID token %v (%T)", token.Extra("id_token"), token.Extra("id_token"))
 			http.Error(w, "Internal error", http.StatusInternalServerError)
 			return
 		}
+		a.checkRequestedClaims(r.Context(), idToken)
+		if claim := a.config().UserKeyClaim; claim != "" {
+			var claims map[string]interface{}
+			err = decodeTokenSegment(idToken, 1, &claims)
+			if err == nil {
+				_, err = userKey(claims, claim)
+			}
+			if err != nil {
+				a.logr(r.Context(), "Invalid ID token user key: %s", err)
+				http.Error(w, "Authorization failure", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		newToken := fromOauth2(token)
+		newToken.LoginAt = a.now().Unix()
+		newToken.SessionID = tokenSessionID(idToken)
 
-		err = a.setCookie(w, fromOauth2(token))
+		cfg := a.config()
+		granted := grantedScopes(token, cfg.Scopes)
+		if missing := missingScopes(cfg.Scopes, granted); len(missing) > 0 {
+			a.logr(r.Context(), "Scopes %v were requested but not granted", missing)
+			newToken.GrantedScopes = granted
+			if cfg.OnScopeDowngrade != nil {
+				err = cfg.OnScopeDowngrade(cfg.Scopes, granted)
+				if err != nil {
+					a.logr(r.Context(), "Login denied since not all scopes were granted: %v", err)
+					a.audit(r, AuditEvent{Type: AuditLogin, Subject: unverifiedSubject(idToken), Outcome: AuditFailure, Reason: err.Error()})
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+			}
+		}
+		err = a.setLoginCookie(w, newToken)
 		if err != nil {
-			a.logf("Failed setting cookie: %v", err)
+			a.logr(r.Context(), "Failed setting cookie: %v", err)
 			http.Error(w, "Internal error", http.StatusInternalServerError)
 			return
 		}
 
-		redirectPath := r.URL.Query().Get("state")
 		if redirectPath == "" {
 			redirectPath = "/"
 		}
-		a.logf("Successfully exchanged token, redirect back to application path %q", redirectPath)
-		http.Redirect(w, r, redirectPath, http.StatusTemporaryRedirect)
-	})
+		a.logr(r.Context(), "Successfully exchanged token, redirect back to application path %q", redirectPath)
+		a.audit(r, AuditEvent{Type: AuditLogin, Subject: unverifiedSubject(idToken)})
+		status := cfg.PostLoginRedirectStatus
+		if r.Method == http.MethodPost {
+			// A 307 would repeat the cross site post, that doesn't carry the session cookie.
+			status = http.StatusSeeOther
+		}
+		http.Redirect(w, r, redirectPath, status)
+	}))
+}
+
+// LoginHandler starts the OAuth2 login flow. After login, the user is redirected to the path in
+// the "next" query parameter, or to "/" if it is not given. It can be mounted on an http endpoint
+// for explicit login links.
+//
+// With the "prompt=select_account" query parameter, the user can choose another account, see
+// `Auth.SwitchAccountURL`. The current session is kept until the login completes and replaces
+// it, such that a cross site link to this handler can't log the user out.
+func (a *Auth) LoginHandler() http.Handler {
+	return a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.config().Disable {
+			http.Redirect(w, r, localPath(r.URL.Query().Get("next")), http.StatusTemporaryRedirect)
+			return
+		}
+		if a.deniesAll() {
+			a.maintenance(w, r)
+			return
+		}
+		selectAccount := r.URL.Query().Get("prompt") == "select_account"
+		a.login(w, r, localPath(r.URL.Query().Get("next")), selectAccount)
+	}))
+}
+
+// AuthRoutes mounts only the authentication routes on the mux: the `RedirectHandler` on the path
+// of cfg.OAuth2.RedirectURL, the `LoginHandler` on loginPath and the `LogoutHandler` on
+// logoutPath, that redirects to "/" after logout. The authentication routes don't depend on any
+// application handler, therefore they can be served on a separate mux, and keep working when the
+// application handlers fail.
+func (a *Auth) AuthRoutes(mux *http.ServeMux, loginPath, logoutPath string) {
+	a.loginPath.Store(loginPath)
+	mux.Handle(loginPath, a.LoginHandler())
+	mux.Handle(logoutPath, a.LogoutHandler("/"))
+	if a.config().Disable {
+		return
+	}
+	mux.Handle(a.redirectPath(), a.RedirectHandler())
+}
+
+// Routes mounts the given handler on the pattern, wrapped with `Authenticate`, together with the
+// `RedirectHandler` on the path of cfg.OAuth2.RedirectURL. It can be used instead of mounting the
+// two handlers separately such that they can't get out of sync. The options are passed to
+// `Authenticate`.
+func (a *Auth) Routes(mux *http.ServeMux, pattern string, handler http.Handler, opts ...Option) {
+	mux.Handle(pattern, a.Authenticate(handler, opts...))
+	if a.config().Disable {
+		return
+	}
+	mux.Handle(a.redirectPath(), a.RedirectHandler())
+}
+
+// redirectPath returns the path of cfg.OAuth2.RedirectURL, on which the RedirectHandler should be
+// mounted.
+func (a *Auth) redirectPath() string {
+	redirectURL := a.config().RedirectURL
+	u, err := url.Parse(redirectURL)
+	if err != nil || u.Path == "" {
+		panic(fmt.Sprintf("auth: can't mount redirect handler for redirect URL %q", redirectURL))
+	}
+	return u.Path
+}
+
+// crawlerAgents are user agent substrings of common bots and crawlers.
+var crawlerAgents = []string{
+	"bot", "crawler", "spider", "slurp", "facebookexternalhit", "embedly", "preview",
+	"curl/", "wget/", "python-requests", "go-http-client",
+}
+
+// IsCrawler returns whether the request user agent looks like a bot or a crawler. It can be used
+// as the `Config.IsBot` function. Requests without a user agent are considered crawlers.
+func IsCrawler(r *http.Request) bool {
+	agent := strings.ToLower(r.UserAgent())
+	if agent == "" {
+		return true
+	}
+	for _, crawler := range crawlerAgents {
+		if strings.Contains(agent, crawler) {
+			return true
+		}
+	}
+	return false
 }
 
 // User returns the credentials of the logged in user. It returns nil in case that there is no
@@ -274,55 +1184,420 @@ func User(ctx context.Context) *Creds {
 
 // LogoutHandler can be mounted on an http endpoint for logging out. It will redirect to
 // the given path after user is navigating to the logout path.
+//
+// Logout is allowed with GET, for logout links, and with POST, for logout forms. POST requests
+// should have the "csrf_token" form value of `Auth.LogoutCSRFToken`. When Config.LogoutRequirePOST
+// is set, GET requests get a confirmation form instead of logging out.
 func (a *Auth) LogoutHandler(redirectPath string) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			if a.config().LogoutRequirePOST {
+				a.logoutForm(w, r)
+				return
+			}
+		case http.MethodPost:
+			if !a.validLogoutCSRFToken(r) {
+				a.logr(r.Context(), "Invalid logout CSRF token")
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			// Redirect the form post to a GET request.
+			a.auditLogout(r)
+			a.clearCookie(w)
+			http.Redirect(w, r, redirectPath, http.StatusSeeOther)
+			return
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.auditLogout(r)
 		a.clearCookie(w)
 		http.Redirect(w, r, redirectPath, http.StatusTemporaryRedirect)
-	})
+	}))
 }
 
 type token struct {
 	*oauth2.Token
 	// Extras:
 	IDToken string `json:"id_token"`
+	// LoginAt is the unix time of the login that created the session. It is kept when the
+	// token is refreshed.
+	LoginAt int64 `json:"login_at,omitempty"`
+	// GrantedScopes are the scopes that the provider granted on login, only if it did not grant
+	// all the requested scopes. It is kept when the token is refreshed.
+	GrantedScopes []string `json:"granted_scopes,omitempty"`
+	// SessionID is the "sid" claim of the ID token, that identifies the session at the provider.
+	// It is kept when the token is refreshed, if the refreshed ID token does not have it.
+	SessionID string `json:"sid,omitempty"`
 }
 
 func (a *Auth) logf(format string, args ...interface{}) {
-	if a.cfg.Log == nil {
+	log := a.config().Log
+	if log == nil {
+		return
+	}
+
+	log(format, args...)
+}
+
+// verifyStrict applies the StrictMode checks on a validated ID token.
+func (a *Auth) verifyStrict(payload *idtoken.Payload, t *token) error {
+	alg, err := tokenAlg(t.IDToken)
+	if err != nil {
+		return err
+	}
+	if alg != "RS256" {
+		return fmt.Errorf("unexpected signing algorithm %q", alg)
+	}
+
+	for _, claim := range []string{"email", "name"} {
+		if v, _ := payload.Claims[claim].(string); v == "" {
+			return fmt.Errorf("missing %q claim", claim)
+		}
+	}
+	if verified, _ := payload.Claims["email_verified"].(bool); !verified {
+		return fmt.Errorf("email is not verified")
+	}
+	if azp, _ := payload.Claims["azp"].(string); azp != a.config().ClientID {
+		return fmt.Errorf("authorized party %q does not match client ID", azp)
+	}
+	if payload.IssuedAt == 0 {
+		return fmt.Errorf("missing \"iat\" claim")
+	}
+	if issuedAt := time.Unix(payload.IssuedAt, 0); issuedAt.After(a.now().Add(maxClockSkew)) {
+		return fmt.Errorf("token issued in the future: %s", issuedAt)
+	}
+	if t.Token != nil && t.AccessToken != "" {
+		atHash, _ := payload.Claims["at_hash"].(string)
+		if atHash != accessTokenHash(t.AccessToken) {
+			return fmt.Errorf("access token hash mismatch")
+		}
+	}
+	return nil
+}
+
+// checkRequestedClaims logs a warning for each ID token claim that was requested using
+// RequestClaims but is missing from the given ID token.
+func (a *Auth) checkRequestedClaims(ctx context.Context, idToken string) {
+	requestClaims := a.config().RequestClaims
+	if len(requestClaims) == 0 {
+		return
+	}
+	requested, err := requestedIDTokenClaims(requestClaims)
+	if err != nil {
+		a.logr(ctx, "Failed parsing requested claims: %v", err)
+		return
+	}
+	var claims map[string]interface{}
+	err = decodeTokenSegment(idToken, 1, &claims)
+	if err != nil {
+		a.logr(ctx, "Failed decoding ID token claims: %v", err)
+		return
+	}
+	for _, claim := range requested {
+		if _, ok := claims[claim]; !ok {
+			a.logr(ctx, "Requested claim %q is missing from the ID token", claim)
+		}
+	}
+}
+
+// requestedIDTokenClaims returns the names of the ID token claims in the OIDC claims request
+// parameter.
+func requestedIDTokenClaims(requestClaims json.RawMessage) ([]string, error) {
+	var r struct {
+		IDToken map[string]json.RawMessage `json:"id_token"`
+	}
+	err := json.Unmarshal(requestClaims, &r)
+	if err != nil {
+		return nil, err
+	}
+	var claims []string
+	for claim := range r.IDToken {
+		claims = append(claims, claim)
+	}
+	sort.Strings(claims)
+	return claims, nil
+}
+
+// tokenAlg returns the signing algorithm from a JWT header.
+func tokenAlg(jwt string) (string, error) {
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	err := decodeTokenSegment(jwt, 0, &h)
+	if err != nil {
+		return "", err
+	}
+	return h.Alg, nil
+}
+
+// decodeTokenSegment decodes the JSON of a JWT segment (0 for the header, 1 for the claims) into
+// v. It does not verify the token.
+func decodeTokenSegment(jwt string, i int, v interface{}) error {
+	segments := strings.Split(jwt, ".")
+	if len(segments) <= i {
+		return fmt.Errorf("malformed token")
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(segments[i])
+	if err != nil {
+		return fmt.Errorf("failed decoding token segment: %v", err)
+	}
+	err = json.Unmarshal(decoded, v)
+	if err != nil {
+		return fmt.Errorf("failed json decoding token segment: %v", err)
+	}
+	return nil
+}
+
+// accessTokenHash returns the "at_hash" value for the access token, as defined in
+// https://openid.net/specs/openid-connect-core-1_0.html#CodeIDToken.
+func accessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// login redirects the user to the OAuth2 consent page to ask for permission for the configured
+// scopes. After login, the user is redirected back to redirectPath.
+//
+// When selectAccount is set, the provider lets the user choose the account to login with. This
+// is allowed during a denial cooldown, since the user may choose another account.
+func (a *Auth) login(w http.ResponseWriter, r *http.Request, redirectPath string, selectAccount bool) {
+	cfg := a.config()
+	if key, ok := a.inCooldown(r); ok && !selectAccount {
+		a.forbidden(w, r)
+		a.logr(r.Context(), "User %q was denied repeatedly, skip login during cooldown", key)
+		a.audit(r, AuditEvent{Type: AuditDenied, Outcome: AuditFailure, Reason: "denial cooldown"})
+		return
+	}
+	redirectURL, err := a.redirectURL(r)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		a.logr(r.Context(), "Failed getting redirect URL: %s", err)
+		return
+	}
+	a.checkRedirectHandler(redirectURL)
+	state := a.authState(redirectPath, redirectURL)
+	authOpts := []oauth2.AuthCodeOption{oauth2.ApprovalForce, oauth2.AccessTypeOffline}
+	if cfg.FormPostCallback {
+		state, err = a.formPostState(w, state)
+		if err != nil {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			a.logr(r.Context(), "Failed creating form post state: %s", err)
+			return
+		}
+		authOpts = append(authOpts, oauth2.SetAuthURLParam("response_mode", "form_post"))
+	}
+	if selectAccount {
+		authOpts = append(authOpts, oauth2.SetAuthURLParam("prompt", "select_account consent"))
+	}
+	if len(cfg.RequestClaims) > 0 {
+		authOpts = append(authOpts, oauth2.SetAuthURLParam("claims", string(cfg.RequestClaims)))
+	}
+	authURL := a.oauth2Config(redirectURL).AuthCodeURL(state, authOpts...)
+	if cfg.UsePAR {
+		authURL, err = a.pushAuthRequest(r.Context(), authURL)
+		if err != nil {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			a.logr(r.Context(), "Failed pushing authorization request: %s", err)
+			return
+		}
+	}
+	http.Redirect(w, r, authURL, cfg.LoginRedirectStatus)
+}
+
+// localPath returns the path if it is a local path, and "/" otherwise, to prevent redirects to
+// other sites.
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
+
+// checkRedirectHandler logs a diagnostic if users are redirected to the OAuth2 flow while the
+// RedirectHandler was never created, since the flow can't complete in this case.
+func (a *Auth) checkRedirectHandler(redirectURL string) {
+	if atomic.LoadInt32(&a.hasRedirectHandler) == 1 {
 		return
 	}
+	a.warnRedirectHandler.Do(func() {
+		a.logf("Redirecting to login, but RedirectHandler was not created. It should be mounted "+
+			"on the path of %q, or use Routes to mount it together with the handler.", redirectURL)
+	})
+}
+
+// redirectURL returns the OAuth2 redirect URL for the given request.
+func (a *Auth) redirectURL(r *http.Request) (string, error) {
+	cfg := a.config()
+	if cfg.RedirectURLFunc == nil {
+		return cfg.RedirectURL, nil
+	}
 
-	a.cfg.Log(format, args...)
+	redirectURL, err := cfg.RedirectURLFunc(r)
+	if err != nil {
+		return "", err
+	}
+	err = validateRedirectURL(redirectURL, cfg.RedirectDomains)
+	if err != nil {
+		return "", err
+	}
+	return redirectURL, nil
+}
+
+// authState returns the OAuth2 state for an authentication request. The state holds the path to
+// redirect to after login. When the redirect URL is computed per request, the state also holds
+// the redirect URL, such that the exact same URL is sent on the code exchange.
+func (a *Auth) authState(redirectPath, redirectURL string) string {
+	if a.config().RedirectURLFunc == nil {
+		return redirectPath
+	}
+	return url.Values{afterKey: {redirectPath}, redirectKey: {redirectURL}}.Encode()
+}
+
+// parseState returns the path to redirect to after login and the redirect URL that was used in
+// the authentication request, from the state that was created by authState.
+func (a *Auth) parseState(state string) (redirectPath, redirectURL string, err error) {
+	cfg := a.config()
+	if cfg.RedirectURLFunc == nil {
+		return state, cfg.RedirectURL, nil
+	}
+
+	v, err := url.ParseQuery(state)
+	if err != nil {
+		return "", "", fmt.Errorf("failed parsing state: %v", err)
+	}
+	redirectURL = v.Get(redirectKey)
+	err = validateRedirectURL(redirectURL, cfg.RedirectDomains)
+	if err != nil {
+		return "", "", err
+	}
+	return v.Get(afterKey), redirectURL, nil
+}
+
+// validateRedirectURL checks that a dynamic redirect URL is in the allowed domains.
+func validateRedirectURL(redirectURL string, domains []string) error {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		return fmt.Errorf("invalid redirect URL %q: %v", redirectURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("redirect URL %q has invalid scheme", redirectURL)
+	}
+	if !matchDomain(u.Hostname(), domains) {
+		return fmt.Errorf("redirect URL %q is not in the allowed domains", redirectURL)
+	}
+	return nil
+}
+
+// oauth2Config returns the OAuth2 config with the given redirect URL.
+func (a *Auth) oauth2Config(redirectURL string) *oauth2.Config {
+	cfg := a.config().Config
+	cfg.RedirectURL = redirectURL
+	return &cfg
+}
+
+// providerContext returns a context that makes the oauth2 package use the provider client.
+func (a *Auth) providerContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, a.client)
 }
 
 func (a *Auth) clearCookie(w http.ResponseWriter) {
+	cfg := a.config()
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    "",
+		Expires:  a.now(),
+		Path:     cfg.Path,
+		Secure:   cfg.cookieSecure(),
+		HttpOnly: true,
+		SameSite: cfg.sessionSameSite(),
+	})
+	if cfg.StrictSameSiteBootstrap {
+		a.clearBootstrapCookie(w)
+	}
+	if cfg.PublicCookie != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:    cfg.PublicCookie,
+			Value:   "",
+			Expires: a.now(),
+			Path:    cfg.Path,
+			Secure:  cfg.cookieSecure(),
+		})
+	}
+	if cfg.IssueIdentityJWT != nil && cfg.IssueIdentityJWT.Cookie != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:    cfg.IssueIdentityJWT.Cookie,
+			Value:   "",
+			Expires: a.now(),
+			Path:    cfg.Path,
+			Secure:  cfg.cookieSecure(),
+		})
+	}
+}
+
+// publicCreds are the user fields that are stored in the public cookie.
+type publicCreds struct {
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+}
+
+// setPublicCookie sets the public cookie if its value in the request is not up to date.
+func (a *Auth) setPublicCookie(w http.ResponseWriter, r *http.Request, creds *Creds) {
+	cfg := a.config()
+	jsonEncoded, err := json.Marshal(publicCreds{Name: creds.Name, Picture: creds.Picture})
+	if err != nil {
+		a.logr(r.Context(), "Failed encoding public cookie: %v", err)
+		return
+	}
+	value := base64.URLEncoding.EncodeToString(jsonEncoded)
+	if cookie, err := r.Cookie(cfg.PublicCookie); err == nil && cookie.Value == value {
+		return
+	}
 	http.SetCookie(w, &http.Cookie{
-		Name:    cookieName,
-		Value:   "",
-		Expires: time.Now(),
-		Secure:  !a.cfg.Unsecure,
+		Name:    cfg.PublicCookie,
+		Value:   value,
+		Expires: a.now().Add(time.Hour * 24 * 365 * 10), // No expiry.
+		Path:    cfg.Path,
+		Secure:  cfg.cookieSecure(),
 	})
 }
 
 func (a *Auth) setCookie(w http.ResponseWriter, token *token) error {
+	_, err := a.writeCookie(w, token)
+	return err
+}
+
+// writeCookie sets the session cookie and returns its value.
+func (a *Auth) writeCookie(w http.ResponseWriter, token *token) (string, error) {
+	cfg := a.config()
+	token = token.stored(cfg)
 	jsonEncoded, err := json.Marshal(token)
 	if err != nil {
-		return err
+		return "", err
 	}
 	base64Encoded := base64.StdEncoding.EncodeToString(jsonEncoded)
-	http.SetCookie(w, &http.Cookie{
-		Name:    cookieName,
-		Value:   base64Encoded,
-		Expires: time.Now().Add(time.Hour * 24 * 365 * 10), // No expiry.
-		Path:    a.cfg.Path,
-		Secure:  !a.cfg.Unsecure,
-	})
-	return nil
+	cookie := &http.Cookie{
+		Name:     cookieName,
+		Value:    base64Encoded,
+		Expires:  a.now().Add(time.Hour * 24 * 365 * 10), // No expiry.
+		Path:     cfg.Path,
+		Secure:   cfg.cookieSecure(),
+		HttpOnly: true,
+		SameSite: cfg.sessionSameSite(),
+	}
+	if size := len(cookie.Name) + len(cookie.Value); cfg.MaxCookieBytes > 0 && size > cfg.MaxCookieBytes {
+		return "", fmt.Errorf("session cookie size %d bytes exceeds the maximum of %d bytes and will "+
+			"be dropped by the browser; reduce the requested scopes or the provider token size, or "+
+			"increase MaxCookieBytes if all clients support larger cookies", size, cfg.MaxCookieBytes)
+	}
+	http.SetCookie(w, cookie)
+	return base64Encoded, nil
 }
 
 func (a *Auth) getCookie(r *http.Request) (*token, error) {
 	// Get the token from the cookie.
-	cookie, err := r.Cookie(cookieName)
+	cookie, err := a.sessionCookie(r)
 	switch {
 	case err == http.ErrNoCookie || cookie.Value == "":
 		return nil, nil
@@ -349,6 +1624,141 @@ func fromOauth2(t *oauth2.Token) *token {
 	}
 }
 
+// stored returns a copy of the token with only the fields that should be stored in the session,
+// according to the configuration.
+func (t *token) stored(cfg *Config) *token {
+	oauth2Token := *t.Token
+	if !boolValue(cfg.StoreAccessToken, true) {
+		oauth2Token.AccessToken = ""
+	}
+	if !boolValue(cfg.StoreRefreshToken, true) {
+		oauth2Token.RefreshToken = ""
+	}
+	stored := *t
+	stored.Token = &oauth2Token
+	return &stored
+}
+
 func (t *token) toOauth2() *oauth2.Token {
 	return t.Token.WithExtra(map[string]interface{}{"id_token": t.IDToken})
 }
+
+// boolValue returns the value of an optional bool, or def if it is not set.
+func boolValue(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
+// userKey returns the value of the user key claim from the ID token claims.
+func userKey(claims map[string]interface{}, claim string) (string, error) {
+	switch v := claims[claim].(type) {
+	case string:
+		if v != "" {
+			return v, nil
+		}
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("missing user key claim %q", claim)
+}
+
+// checkTokenType checks that the token response of the provider is of the "Bearer" token type,
+// which is the only type that the access token is used as. A missing token type is treated as
+// "Bearer".
+func checkTokenType(t *oauth2.Token) error {
+	if !strings.EqualFold(t.Type(), "Bearer") {
+		return fmt.Errorf("unexpected token type %q, expected \"Bearer\"", t.TokenType)
+	}
+	return nil
+}
+
+// isRedirect returns whether the HTTP status is a redirect status.
+func isRedirect(status int) bool {
+	return status >= 300 && status < 400
+}
+
+// matchDomain returns whether the host matches one of the domain patterns. A pattern that starts
+// with "*." matches any subdomain of the rest of the pattern.
+func matchDomain(host string, patterns []string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) && len(host) > len(pattern)-1 {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// providerError is returned when the OAuth2 provider responds with a body that is not in one of
+// the formats that the oauth2 package can decode, for example an HTML error page during an
+// outage.
+type providerError struct {
+	Status      string
+	ContentType string
+	// Snippet is the beginning of the response body.
+	Snippet string
+}
+
+func (e *providerError) Error() string {
+	return fmt.Sprintf("provider responded with status %q and unexpected content type %q: %s",
+		e.Status, e.ContentType, e.Snippet)
+}
+
+// providerTransport checks that responses from the provider can be decoded, and otherwise
+// converts them to a descriptive providerError.
+type providerTransport struct {
+	base http.RoundTripper
+}
+
+func (t *providerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if decodable(contentType) {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorSnippet))
+	return nil, &providerError{
+		Status:      resp.Status,
+		ContentType: contentType,
+		Snippet:     strings.TrimSpace(string(snippet)),
+	}
+}
+
+// decodable returns whether a response with the given content type can be decoded by the oauth2
+// package. A missing content type is allowed since some providers omit it for JSON responses.
+func decodable(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "application/json",
+		mediaType == "application/x-www-form-urlencoded",
+		mediaType == "text/plain",
+		strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+	return false
+}
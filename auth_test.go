@@ -6,6 +6,7 @@ import (
 	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,13 +16,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
+	"google.golang.org/api/idtoken"
 )
 
 func TestDisable(t *testing.T) {
@@ -39,9 +42,7 @@ func TestDisable(t *testing.T) {
 func TestAuthenticate(t *testing.T) {
 	t.Parallel()
 
-	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
-	require.NoError(t, err)
-	privateKeyCert := newCert(privateKey, "keyid")
+	privateKey, privateKeyCert := newTestKey(t)
 
 	oauth2Cfg := oauth2.Config{
 		ClientID:     "client1",
@@ -74,7 +75,9 @@ func TestAuthenticate(t *testing.T) {
 
 	// For redirect requests, this is the expected redirect URL.
 	wantRedirectURL := oauth2Cfg.Endpoint.AuthURL +
-		fmt.Sprintf("?client_id=%s", oauth2Cfg.ClientID) +
+		"?access_type=offline" +
+		fmt.Sprintf("&client_id=%s", oauth2Cfg.ClientID) +
+		"&prompt=consent" +
 		"&redirect_uri=" + url.QueryEscape(oauth2Cfg.RedirectURL) +
 		"&response_type=code" +
 		"&scope=scope1+scope2" +
@@ -83,6 +86,7 @@ func TestAuthenticate(t *testing.T) {
 	tests := []struct {
 		name   string
 		cookie *http.Cookie
+		opts   []Option
 		assert func(*testing.T, *httptest.ResponseRecorder)
 	}{
 		{
@@ -113,6 +117,39 @@ func TestAuthenticate(t *testing.T) {
 				assert.Equal(t, http.StatusUnauthorized, rec.Result().StatusCode)
 			},
 		},
+		{
+			name: "authorized user",
+			cookie: &http.Cookie{
+				Name:  cookieName,
+				Value: base64Encoded,
+			},
+			opts: []Option{WithAuthorize(func(c *Creds) bool { return c.Email == email })},
+			assert: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				assert.Equal(t, http.StatusOK, rec.Result().StatusCode)
+				assert.Equal(t, responseText, rec.Body.String())
+			},
+		},
+		{
+			name: "unauthorized user is forbidden",
+			cookie: &http.Cookie{
+				Name:  cookieName,
+				Value: base64Encoded,
+			},
+			opts: []Option{WithAuthorize(func(c *Creds) bool { return false })},
+			assert: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				assert.Equal(t, http.StatusForbidden, rec.Result().StatusCode)
+			},
+		},
+		{
+			name: "redirect with path",
+			opts: []Option{WithPath("/tool")},
+			assert: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				assert.Equal(t, http.StatusTemporaryRedirect, rec.Result().StatusCode)
+				location, err := url.Parse(rec.Result().Header.Get("Location"))
+				require.NoError(t, err)
+				assert.Equal(t, "/tool", location.Query().Get("state"))
+			},
+		},
 		{
 			name: "empty cookie is redirected",
 			cookie: &http.Cookie{
@@ -141,7 +178,7 @@ func TestAuthenticate(t *testing.T) {
 				assert.Equal(t, email, gotCreds.Email)
 				assert.Equal(t, name, gotCreds.Name)
 				w.Write([]byte(responseText))
-			}))
+			}), tt.opts...)
 
 			rec := httptest.NewRecorder()
 			req := httptest.NewRequest(http.MethodGet, requestPath, nil)
@@ -159,6 +196,8 @@ func TestRedirect(t *testing.T) {
 	t.Parallel()
 
 	validCode := "code"
+	outageCode := "outage"
+	macCode := "mac"
 	statePath := "/next"
 	tkn := struct {
 		TokenType    string `json:"token_type"`
@@ -211,6 +250,21 @@ func TestRedirect(t *testing.T) {
 				assert.Equal(t, http.StatusUnauthorized, rec.Result().StatusCode)
 			},
 		},
+		{
+			name: "html error page",
+			code: outageCode,
+			assert: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				assert.Equal(t, http.StatusUnauthorized, rec.Result().StatusCode)
+			},
+		},
+		{
+			name: "non bearer token type",
+			code: macCode,
+			assert: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				assert.Equal(t, http.StatusUnauthorized, rec.Result().StatusCode)
+				assert.Empty(t, rec.Result().Cookies())
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -222,7 +276,17 @@ func TestRedirect(t *testing.T) {
 					// Check that the code in the request is the expected code.
 					err := r.ParseForm()
 					require.NoError(t, err)
-					if r.FormValue("code") != validCode {
+					if r.FormValue("code") == outageCode {
+						// Provider outage. Return an HTML error page.
+						w.Header().Set("Content-Type", "text/html")
+						w.WriteHeader(http.StatusServiceUnavailable)
+						fmt.Fprint(w, "<html>Service Unavailable</html>")
+						return
+					}
+					resp := tkn
+					if r.FormValue("code") == macCode {
+						resp.TokenType = "mac"
+					} else if r.FormValue("code") != validCode {
 						// Invalid code. Return non 2xx response.
 						w.WriteHeader(http.StatusUnauthorized)
 						return
@@ -230,7 +294,7 @@ func TestRedirect(t *testing.T) {
 
 					// In case of valid code, encode the token into the response.
 					w.Header().Set("Content-Type", "application/json")
-					err = json.NewEncoder(w).Encode(tkn)
+					err = json.NewEncoder(w).Encode(resp)
 					require.NoError(t, err)
 				default:
 					t.Fatalf("Unexpected path: %s", path)
@@ -270,6 +334,562 @@ func TestRedirect(t *testing.T) {
 	}
 }
 
+func TestRedirectURLFunc(t *testing.T) {
+	t.Parallel()
+
+	validCode := "code"
+
+	// Create oauth2 server that checks the redirect URL that is sent on exchange.
+	oauth2Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		require.NoError(t, err)
+		if r.FormValue("code") != validCode || r.FormValue("redirect_uri") != "https://pr-1.app.example.com/auth" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"access","token_type":"bearer","id_token":"id token"}`)
+	}))
+	defer oauth2Server.Close()
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{
+			ClientID:     "client1",
+			ClientSecret: "secret1",
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  oauth2Server.URL + "/auth",
+				TokenURL: oauth2Server.URL + "/token",
+			},
+		},
+		RedirectURLFunc: func(r *http.Request) (string, error) {
+			return "https://" + r.Host + "/auth", nil
+		},
+		RedirectDomains: []string{"*.app.example.com"},
+		Log:             t.Logf,
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		host       string
+		wantStatus int
+	}{
+		{name: "allowed domain", host: "pr-1.app.example.com", wantStatus: http.StatusTemporaryRedirect},
+		{name: "parent domain", host: "app.example.com", wantStatus: http.StatusInternalServerError},
+		{name: "other domain", host: "pr-1.app.example.org", wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Check the redirect URL in the authentication redirect.
+			req := httptest.NewRequest(http.MethodGet, "/path", nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+			a.Authenticate(http.NotFoundHandler()).ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantStatus, rec.Result().StatusCode)
+			if tt.wantStatus != http.StatusTemporaryRedirect {
+				return
+			}
+			location, err := url.Parse(rec.Result().Header.Get("Location"))
+			require.NoError(t, err)
+			assert.Equal(t, "https://"+tt.host+"/auth", location.Query().Get("redirect_uri"))
+
+			// Check that the same redirect URL is sent in the code exchange, even if the callback
+			// request arrives on a different host, for example behind a proxy.
+			v := url.Values{}
+			v.Set("code", validCode)
+			v.Set("state", location.Query().Get("state"))
+			req = httptest.NewRequest(http.MethodGet, "/auth?"+v.Encode(), nil)
+			req.Host = "internal:8080"
+			rec = httptest.NewRecorder()
+			a.RedirectHandler().ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusTemporaryRedirect, rec.Result().StatusCode)
+			assert.Equal(t, "/path", rec.Result().Header.Get("Location"))
+		})
+	}
+
+	t.Run("state with other domain", func(t *testing.T) {
+		v := url.Values{}
+		v.Set("code", validCode)
+		v.Set("state", url.Values{afterKey: {"/path"}, redirectKey: {"https://evil.com/auth"}}.Encode())
+		req := httptest.NewRequest(http.MethodGet, "/auth?"+v.Encode(), nil)
+		rec := httptest.NewRecorder()
+		a.RedirectHandler().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Result().StatusCode)
+	})
+}
+
+func TestRedirectURLFuncRequiresDomains(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(context.Background(), Config{
+		RedirectURLFunc: func(r *http.Request) (string, error) { return "https://example.com/auth", nil },
+	})
+	assert.Error(t, err)
+}
+
+func TestRoutes(t *testing.T) {
+	t.Parallel()
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{
+			ClientID:    "client1",
+			RedirectURL: "https://example.com/oauth/callback",
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  "https://auth.com/auth",
+				TokenURL: "https://auth.com/token",
+			},
+		},
+		Log: t.Logf,
+	})
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	a.Routes(mux, "/", http.NotFoundHandler())
+
+	// The handler is authenticated.
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/path", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Result().StatusCode)
+	assert.Equal(t, int32(1), a.hasRedirectHandler)
+
+	// The redirect handler is mounted on the redirect URL path.
+	_, pattern := mux.Handler(httptest.NewRequest(http.MethodGet, "/oauth/callback", nil))
+	assert.Equal(t, "/oauth/callback", pattern)
+}
+
+func TestAuthRoutes(t *testing.T) {
+	t.Parallel()
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{
+			ClientID:    "client1",
+			RedirectURL: "https://example.com/oauth/callback",
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  "https://auth.com/auth",
+				TokenURL: "https://auth.com/token",
+			},
+		},
+		Log: t.Logf,
+	})
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	a.AuthRoutes(mux, "/login", "/logout")
+
+	tests := []struct {
+		path      string
+		wantState string
+	}{
+		{path: "/login?next=/foo", wantState: "/foo"},
+		{path: "/login", wantState: "/"},
+		{path: "/login?next=https://evil.com", wantState: "/"},
+		{path: "/login?next=//evil.com", wantState: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, http.StatusTemporaryRedirect, rec.Result().StatusCode)
+			location, err := url.Parse(rec.Result().Header.Get("Location"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantState, location.Query().Get("state"))
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/logout", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Result().StatusCode)
+	assert.Equal(t, "/", rec.Result().Header.Get("Location"))
+
+	_, pattern := mux.Handler(httptest.NewRequest(http.MethodGet, "/oauth/callback", nil))
+	assert.Equal(t, "/oauth/callback", pattern)
+}
+
+func TestRedirectStatus(t *testing.T) {
+	t.Parallel()
+
+	a, err := New(context.Background(), Config{
+		Config:              oauth2.Config{RedirectURL: "https://example.com/auth"},
+		LoginRedirectStatus: http.StatusFound,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTemporaryRedirect, a.cfg.PostLoginRedirectStatus)
+
+	rec := httptest.NewRecorder()
+	a.Authenticate(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusFound, rec.Result().StatusCode)
+
+	_, err = New(context.Background(), Config{PostLoginRedirectStatus: http.StatusOK})
+	assert.Error(t, err)
+}
+
+func TestStrictMode(t *testing.T) {
+	t.Parallel()
+
+	a := &Auth{cfg: &Config{Config: oauth2.Config{ClientID: "client1"}, StrictMode: true}}
+
+	rs256 := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`)) + ".payload.signature"
+	es256 := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256"}`)) + ".payload.signature"
+
+	newPayload := func() *idtoken.Payload {
+		return &idtoken.Payload{
+			IssuedAt: time.Now().Unix(),
+			Claims: map[string]interface{}{
+				"email":          "email@example.com",
+				"name":           "John",
+				"email_verified": true,
+				"azp":            "client1",
+				"at_hash":        accessTokenHash("access"),
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		idToken string
+		modify  func(p *idtoken.Payload)
+		wantErr bool
+	}{
+		{name: "valid", idToken: rs256, modify: func(p *idtoken.Payload) {}},
+		{name: "algorithm", idToken: es256, modify: func(p *idtoken.Payload) {}, wantErr: true},
+		{name: "missing name", idToken: rs256, modify: func(p *idtoken.Payload) { delete(p.Claims, "name") }, wantErr: true},
+		{name: "unverified email", idToken: rs256, modify: func(p *idtoken.Payload) { p.Claims["email_verified"] = false }, wantErr: true},
+		{name: "missing azp", idToken: rs256, modify: func(p *idtoken.Payload) { delete(p.Claims, "azp") }, wantErr: true},
+		{name: "other azp", idToken: rs256, modify: func(p *idtoken.Payload) { p.Claims["azp"] = "client2" }, wantErr: true},
+		{name: "missing iat", idToken: rs256, modify: func(p *idtoken.Payload) { p.IssuedAt = 0 }, wantErr: true},
+		{name: "future iat", idToken: rs256, modify: func(p *idtoken.Payload) { p.IssuedAt = time.Now().Add(time.Hour).Unix() }, wantErr: true},
+		{name: "at_hash mismatch", idToken: rs256, modify: func(p *idtoken.Payload) { p.Claims["at_hash"] = "other" }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPayload()
+			tt.modify(p)
+			err := a.verifyStrict(p, &token{Token: &oauth2.Token{AccessToken: "access"}, IDToken: tt.idToken})
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{
+		Config: oauth2.Config{
+			ClientID:    "client1",
+			RedirectURL: "https://example.com/auth",
+			Scopes:      []string{"scope1"},
+		},
+		Log: t.Logf,
+	}
+	a, err := New(context.Background(), cfg)
+	require.NoError(t, err)
+	h := a.Authenticate(http.NotFoundHandler())
+
+	// Change the scopes.
+	cfg.Scopes = []string{"scope2"}
+	err = a.Reload(cfg)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	location, err := url.Parse(rec.Result().Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "scope2", location.Query().Get("scope"))
+
+	// Client credentials can't be changed.
+	cfg.ClientID = "client2"
+	err = a.Reload(cfg)
+	assert.Error(t, err)
+	assert.Equal(t, "client1", a.config().ClientID)
+}
+
+func TestMaxCookieBytes(t *testing.T) {
+	t.Parallel()
+
+	tkn := &token{Token: &oauth2.Token{AccessToken: strings.Repeat("a", 2000)}, IDToken: "id token"}
+
+	a := &Auth{cfg: &Config{MaxCookieBytes: defaultMaxCookieBytes}}
+	rec := httptest.NewRecorder()
+	err := a.setCookie(rec, tkn)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(rec.Result().Cookies()))
+
+	a = &Auth{cfg: &Config{MaxCookieBytes: 1000}}
+	rec = httptest.NewRecorder()
+	err = a.setCookie(rec, tkn)
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(rec.Result().Cookies()))
+}
+
+func TestRequestClaims(t *testing.T) {
+	t.Parallel()
+
+	requestClaims := `{"id_token":{"email":null,"groups":{"essential":true}}}`
+	var logs []string
+	a, err := New(context.Background(), Config{
+		Config:        oauth2.Config{RedirectURL: "https://example.com/auth"},
+		RequestClaims: json.RawMessage(requestClaims),
+		Log:           func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) },
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	a.Authenticate(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	location, err := url.Parse(rec.Result().Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, requestClaims, location.Query().Get("claims"))
+
+	// Check that missing claims are logged.
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"email":"email@example.com"}`))
+	logs = nil
+	a.checkRequestedClaims(context.Background(), header+"."+claims+".signature")
+	assert.Equal(t, []string{`Requested claim "groups" is missing from the ID token`}, logs)
+
+	_, err = New(context.Background(), Config{RequestClaims: json.RawMessage(`["email"]`)})
+	assert.Error(t, err)
+}
+
+func TestStoreTokens(t *testing.T) {
+	t.Parallel()
+
+	storeFalse := false
+
+	_, err := New(context.Background(), Config{StoreIDToken: &storeFalse})
+	assert.Error(t, err)
+
+	a, err := New(context.Background(), Config{
+		Config:            oauth2.Config{RedirectURL: "https://example.com/auth"},
+		StoreAccessToken:  &storeFalse,
+		StoreRefreshToken: &storeFalse,
+		Log:               t.Logf,
+	})
+	require.NoError(t, err)
+
+	// Check that only the ID token is stored.
+	expiry := time.Now().Add(-time.Minute).Round(time.Second)
+	rec := httptest.NewRecorder()
+	err = a.setCookie(rec, &token{
+		Token:   &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: expiry},
+		IDToken: "id token",
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rec.Result().Cookies()))
+	decoded, err := base64.StdEncoding.DecodeString(rec.Result().Cookies()[0].Value)
+	require.NoError(t, err)
+	var got token
+	err = json.Unmarshal(decoded, &got)
+	require.NoError(t, err)
+	assert.Equal(t, "", got.AccessToken)
+	assert.Equal(t, "", got.RefreshToken)
+	assert.Equal(t, "id token", got.IDToken)
+	assert.True(t, expiry.Equal(got.Expiry))
+
+	// An expired session that can't be refreshed is redirected to login.
+	req := httptest.NewRequest(http.MethodGet, "/path", nil)
+	req.AddCookie(newTokenCookie(t, &got))
+	rec = httptest.NewRecorder()
+	a.Authenticate(http.NotFoundHandler()).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Result().StatusCode)
+	location, err := url.Parse(rec.Result().Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/path", location.Query().Get("state"))
+}
+
+func TestStoreTokensWithoutRefreshToken(t *testing.T) {
+	t.Parallel()
+
+	privateKey, privateKeyCert := newTestKey(t)
+
+	storeFalse := false
+	a, err := New(context.Background(), Config{
+		Config:            oauth2.Config{ClientID: "client1", RedirectURL: "https://example.com/auth"},
+		StoreRefreshToken: &storeFalse,
+		Log:               t.Logf,
+		Client:            fakeClient(t, certResp{Keys: []cert{privateKeyCert}}),
+	})
+	require.NoError(t, err)
+
+	// A session with a stored access token that did not expire is authenticated.
+	req := httptest.NewRequest(http.MethodGet, "/path", nil)
+	req.AddCookie(newSessionCookie(t, genSignedToken(t, privateKeyCert.KID, privateKey, "client1", "email@example.com", "John")))
+	rec := httptest.NewRecorder()
+	a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 0, len(rec.Result().Cookies()))
+}
+
+func TestCookieSecure(t *testing.T) {
+	t.Parallel()
+
+	secure, unsecure := true, false
+	tests := []struct {
+		name string
+		cfg  Config
+		want bool
+	}{
+		{name: "default", cfg: Config{}, want: true},
+		{name: "unsecure", cfg: Config{Unsecure: true}, want: false},
+		{name: "unsecure with secure cookie", cfg: Config{Unsecure: true, CookieSecure: &secure}, want: true},
+		{name: "unsecure cookie", cfg: Config{CookieSecure: &unsecure}, want: false},
+		{name: "https external URL", cfg: Config{ExternalURL: "https://example.com", Unsecure: true}, want: true},
+		{name: "http external URL", cfg: Config{ExternalURL: "http://example.com"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Auth{cfg: &tt.cfg}
+			rec := httptest.NewRecorder()
+			err := a.setCookie(rec, &token{Token: &oauth2.Token{}, IDToken: "id token"})
+			require.NoError(t, err)
+			require.Equal(t, 1, len(rec.Result().Cookies()))
+			assert.Equal(t, tt.want, rec.Result().Cookies()[0].Secure)
+		})
+	}
+}
+
+func TestUserKeyClaim(t *testing.T) {
+	t.Parallel()
+
+	privateKey, privateKeyCert := newTestKey(t)
+	session := newSessionCookie(t, genSignedToken(t, privateKeyCert.KID, privateKey, "client1", "email@example.com", "John"))
+
+	tests := []struct {
+		claim      string
+		wantStatus int
+		wantKey    string
+	}{
+		{claim: "email", wantStatus: http.StatusOK, wantKey: "email@example.com"},
+		{claim: "oid", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.claim, func(t *testing.T) {
+			a, err := New(context.Background(), Config{
+				Config:       oauth2.Config{ClientID: "client1"},
+				UserKeyClaim: tt.claim,
+				Log:          t.Logf,
+				Client:       fakeClient(t, certResp{Keys: []cert{privateKeyCert}}),
+			})
+			require.NoError(t, err)
+
+			var gotKey string
+			h := a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotKey = User(r.Context()).Key
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(session)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantKey, gotKey)
+		})
+	}
+}
+
+func TestExternalURL(t *testing.T) {
+	t.Parallel()
+
+	a, err := New(context.Background(), Config{
+		Config:      oauth2.Config{ClientID: "client1", RedirectURL: "/auth"},
+		ExternalURL: "https://app.example.com/",
+		Log:         t.Logf,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.example.com/auth", a.config().RedirectURL)
+	assert.Equal(t, "/auth", a.redirectPath())
+
+	rec := httptest.NewRecorder()
+	a.LoginHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	loc, err := url.Parse(rec.Result().Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.example.com/auth", loc.Query().Get("redirect_uri"))
+
+	// An absolute redirect URL is kept.
+	a, err = New(context.Background(), Config{
+		Config:      oauth2.Config{ClientID: "client1", RedirectURL: "https://auth.example.com/auth"},
+		ExternalURL: "https://app.example.com",
+		Log:         t.Logf,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://auth.example.com/auth", a.config().RedirectURL)
+
+	_, err = New(context.Background(), Config{
+		Config:      oauth2.Config{ClientID: "client1", RedirectURL: "/auth"},
+		ExternalURL: "/app",
+	})
+	assert.Error(t, err)
+}
+
+func TestIsBot(t *testing.T) {
+	t.Parallel()
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{RedirectURL: "https://example.com/auth"},
+		IsBot:  IsCrawler,
+	})
+	require.NoError(t, err)
+	h := a.Authenticate(http.NotFoundHandler())
+
+	tests := []struct {
+		agent      string
+		wantStatus int
+	}{
+		{agent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", wantStatus: http.StatusUnauthorized},
+		{agent: "", wantStatus: http.StatusUnauthorized},
+		{agent: "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 Chrome/90.0 Safari/537.36", wantStatus: http.StatusTemporaryRedirect},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.agent, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("User-Agent", tt.agent)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantStatus, rec.Result().StatusCode)
+		})
+	}
+}
+
+func TestPublicCookie(t *testing.T) {
+	t.Parallel()
+
+	a := &Auth{cfg: &Config{PublicCookie: "user"}}
+	creds := &Creds{Email: "email@example.com", Name: "John", Picture: "https://example.com/john.png"}
+
+	rec := httptest.NewRecorder()
+	a.setPublicCookie(rec, httptest.NewRequest(http.MethodGet, "/", nil), creds)
+	require.Equal(t, 1, len(rec.Result().Cookies()))
+	cookie := rec.Result().Cookies()[0]
+	assert.Equal(t, "user", cookie.Name)
+	assert.False(t, cookie.HttpOnly)
+	decoded, err := base64.URLEncoding.DecodeString(cookie.Value)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"John","picture":"https://example.com/john.png"}`, string(decoded))
+
+	// The cookie is not set again when it is up to date.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	a.setPublicCookie(rec, req, creds)
+	assert.Equal(t, 0, len(rec.Result().Cookies()))
+
+	// Both cookies are cleared together.
+	rec = httptest.NewRecorder()
+	a.clearCookie(rec)
+	require.Equal(t, 2, len(rec.Result().Cookies()))
+	assert.True(t, rec.Result().Cookies()[0].HttpOnly)
+	assert.Equal(t, "user", rec.Result().Cookies()[1].Name)
+}
+
 func Test(t *testing.T) {
 	t.Parallel()
 
@@ -321,7 +941,73 @@ func Test(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
-func genSignedToken(t *testing.T, privateKeyID string, privateKey *rsa.PrivateKey, clientID string, email, name string) string {
+func TestProviderTransport(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantErr     bool
+	}{
+		{name: "json", contentType: "application/json; charset=utf-8", body: `{}`},
+		{name: "form", contentType: "application/x-www-form-urlencoded", body: "a=b"},
+		{name: "no content type", body: `{}`},
+		{name: "html", contentType: "text/html", body: strings.Repeat("<p>outage</p>", 100), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header()["Content-Type"] = []string{tt.contentType}
+				w.WriteHeader(http.StatusBadGateway)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer s.Close()
+
+			c := &http.Client{Transport: &providerTransport{}}
+			resp, err := c.Get(s.URL)
+			if !tt.wantErr {
+				require.NoError(t, err)
+				resp.Body.Close()
+				return
+			}
+			var perr *providerError
+			require.True(t, errors.As(err, &perr))
+			assert.Equal(t, "502 Bad Gateway", perr.Status)
+			assert.Equal(t, "text/html", perr.ContentType)
+			assert.Equal(t, maxErrorSnippet, len(perr.Snippet))
+		})
+	}
+}
+
+// newTestKey returns a fixed RSA private key, and its cert with the key ID "keyid".
+func newTestKey(t testing.TB) (*rsa.PrivateKey, cert) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+	return privateKey, newCert(privateKey, "keyid")
+}
+
+// newSessionCookie returns the session cookie of a session with the given ID token, and an access
+// token that expires in an hour.
+func newSessionCookie(t testing.TB, idToken string) *http.Cookie {
+	t.Helper()
+	return newTokenCookie(t, &token{
+		Token:   &oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)},
+		IDToken: idToken,
+	})
+}
+
+// newTokenCookie returns the session cookie of the given token.
+func newTokenCookie(t testing.TB, tok *token) *http.Cookie {
+	t.Helper()
+	jsonEncoded, err := json.Marshal(tok)
+	require.NoError(t, err)
+	return &http.Cookie{Name: cookieName, Value: base64.URLEncoding.EncodeToString(jsonEncoded)}
+}
+
+func genSignedToken(t testing.TB, privateKeyID string, privateKey *rsa.PrivateKey, clientID string, email, name string) string {
 	t.Helper()
 	userClaims := struct {
 		Email string `json:"email"`
@@ -367,12 +1053,12 @@ func newCert(key *rsa.PrivateKey, id string) cert {
 }
 
 // fakeClient returns a client that fakes Google's cert server.
-func fakeClient(t *testing.T, resp certResp) *http.Client {
+func fakeClient(t testing.TB, resp certResp) *http.Client {
 	return &http.Client{Transport: &fakeTransport{t: t, respData: resp}}
 }
 
 type fakeTransport struct {
-	t        *testing.T
+	t        testing.TB
 	respData interface{}
 }
 
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxAvatarBytes is the maximum size of a profile picture that is served by AvatarHandler.
+	maxAvatarBytes = 1 << 20
+	// defaultAvatarTTL is the duration that a profile picture is cached, when the picture
+	// response does not specify it.
+	defaultAvatarTTL = time.Hour
+)
+
+// defaultAvatarHosts is the default Config.AvatarHosts.
+var defaultAvatarHosts = []string{"*.googleusercontent.com"}
+
+// avatarContentTypes are the content types of the profile pictures that are served by
+// AvatarHandler. Only raster images are served, since other images, such as SVG, can run scripts
+// on the application origin.
+var avatarContentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+type avatar struct {
+	contentType string
+	data        []byte
+	expires     time.Time
+}
+
+// AvatarHandler returns an authenticated handler that serves the profile picture of the logged
+// in user. The picture is fetched and cached on the server, such that the browser does not
+// request it from the provider, which would leak the referer and allow tracking. The picture is
+// cached according to its cache headers, and pictures larger than 1MB are not served. Only
+// PNG, JPEG, GIF and WebP pictures of Config.AvatarHosts are served.
+func (a *Auth) AvatarHandler() http.Handler {
+	return a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creds := User(r.Context())
+		if creds == nil || creds.Picture == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		pic, err := a.avatar(r.Context(), creds.Picture)
+		if err != nil {
+			a.logr(r.Context(), "Failed fetching profile picture of %q: %v", creds.Email, err)
+			http.Error(w, "Bad gateway", http.StatusBadGateway)
+			return
+		}
+
+		maxAge := int(pic.expires.Sub(a.now()).Seconds())
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		w.Header().Set("Content-Type", pic.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(pic.data)))
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+		w.Write(pic.data)
+	}))
+}
+
+// avatar returns the profile picture in the given URL, from the cache or from the network.
+func (a *Auth) avatar(ctx context.Context, pictureURL string) (*avatar, error) {
+	now := a.now()
+	a.avatarsMu.Lock()
+	pic, ok := a.avatars[pictureURL]
+	a.avatarsMu.Unlock()
+	if ok && now.Before(pic.expires) {
+		return pic, nil
+	}
+
+	cfg := a.config()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pictureURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.avatarURLAllowed(req.URL) {
+		return nil, fmt.Errorf("picture URL %q is not allowed by AvatarHosts", pictureURL)
+	}
+	client := *cfg.Client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !cfg.avatarURLAllowed(req.URL) {
+			return fmt.Errorf("redirect to %q is not allowed by AvatarHosts", req.URL)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", pictureURL, resp.Status)
+	}
+	contentType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !contains(avatarContentTypes, contentType) {
+		return nil, fmt.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAvatarBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxAvatarBytes {
+		return nil, fmt.Errorf("picture is larger than %d bytes", maxAvatarBytes)
+	}
+
+	ttl, cacheable := avatarTTL(resp.Header.Get("Cache-Control"))
+	pic = &avatar{contentType: contentType, data: data, expires: now.Add(ttl)}
+	if !cacheable {
+		return pic, nil
+	}
+
+	a.avatarsMu.Lock()
+	defer a.avatarsMu.Unlock()
+	if a.avatars == nil {
+		a.avatars = make(map[string]*avatar)
+	}
+	// Drop expired pictures, such that pictures of users that are no longer active don't pile up.
+	for u, p := range a.avatars {
+		if !now.Before(p.expires) {
+			delete(a.avatars, u)
+		}
+	}
+	a.avatars[pictureURL] = pic
+	return pic, nil
+}
+
+// avatarURLAllowed returns whether a profile picture can be fetched from the given URL.
+func (cfg *Config) avatarURLAllowed(u *url.URL) bool {
+	if u.Scheme != "https" || u.User != nil {
+		return false
+	}
+	hosts := cfg.AvatarHosts
+	if len(hosts) == 0 {
+		hosts = defaultAvatarHosts
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range hosts {
+		allowed = strings.ToLower(allowed)
+		if strings.HasPrefix(allowed, "*.") {
+			if strings.HasSuffix(host, allowed[1:]) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// avatarTTL returns the caching duration from the Cache-Control header of a picture response,
+// and whether the picture can be cached at all.
+func avatarTTL(cacheControl string) (time.Duration, bool) {
+	return cacheControlTTL(cacheControl, defaultAvatarTTL)
+}
+
+// cacheControlTTL returns the caching duration from a Cache-Control header, or defaultTTL if it
+// has no max-age, and whether the response can be cached at all.
+func cacheControlTTL(cacheControl string, defaultTTL time.Duration) (time.Duration, bool) {
+	ttl := defaultTTL
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store" || directive == "no-cache":
+			return 0, false
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil {
+				ttl = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return ttl, ttl > 0
+}
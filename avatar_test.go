@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvatar(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	a := &Auth{cfg: &Config{Client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		header := http.Header{"Content-Type": {"image/png"}}
+		body := []byte("png")
+		switch r.URL.Path {
+		case "/no-store":
+			header.Set("Cache-Control", "no-store")
+		case "/large":
+			body = make([]byte, maxAvatarBytes+1)
+		case "/html":
+			header.Set("Content-Type", "text/html")
+		case "/svg":
+			header.Set("Content-Type", "image/svg+xml")
+		case "/jpeg":
+			header.Set("Content-Type", "image/jpeg; charset=binary")
+		default:
+			header.Set("Cache-Control", "public, max-age=60")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(bytes.NewReader(body))}, nil
+	})}, AvatarHosts: []string{"example.com"}}}
+	ctx := context.Background()
+
+	pic, err := a.avatar(ctx, "https://example.com/pic")
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", pic.contentType)
+	assert.Equal(t, []byte("png"), pic.data)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), pic.expires, time.Second)
+
+	// Cached pictures are not fetched again.
+	_, err = a.avatar(ctx, "https://example.com/pic")
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	// Pictures that should not be stored are fetched on every request.
+	_, err = a.avatar(ctx, "https://example.com/no-store")
+	require.NoError(t, err)
+	_, err = a.avatar(ctx, "https://example.com/no-store")
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	_, err = a.avatar(ctx, "https://example.com/large")
+	assert.Error(t, err)
+	_, err = a.avatar(ctx, "https://example.com/html")
+	assert.Error(t, err)
+	_, err = a.avatar(ctx, "https://example.com/svg")
+	assert.Error(t, err)
+	pic, err = a.avatar(ctx, "https://example.com/jpeg")
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", pic.contentType)
+}
+
+func TestAvatarHosts(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	a := &Auth{cfg: &Config{Client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		if r.URL.Path == "/redirect" {
+			header := http.Header{"Location": {"https://internal.example.com/secret"}}
+			return &http.Response{StatusCode: http.StatusFound, Header: header, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+		}
+		header := http.Header{"Content-Type": {"image/png"}}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(bytes.NewReader([]byte("png")))}, nil
+	})}}}
+	ctx := context.Background()
+
+	_, err := a.avatar(ctx, "https://lh3.googleusercontent.com/pic")
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	// Pictures of other hosts are not fetched.
+	for _, pictureURL := range []string{
+		"http://169.254.169.254/latest/meta-data",
+		"https://internal.example.com/pic",
+		"http://lh3.googleusercontent.com/pic",
+		"https://googleusercontent.com.evil.com/pic",
+		"https://user@lh3.googleusercontent.com/pic",
+	} {
+		_, err = a.avatar(ctx, pictureURL)
+		assert.Error(t, err, pictureURL)
+	}
+	assert.Equal(t, 1, calls)
+
+	// Redirects to other hosts are not followed.
+	_, err = a.avatar(ctx, "https://lh3.googleusercontent.com/redirect")
+	assert.Error(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestAvatarTTL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		cacheControl  string
+		wantTTL       time.Duration
+		wantCacheable bool
+	}{
+		{cacheControl: "", wantTTL: defaultAvatarTTL, wantCacheable: true},
+		{cacheControl: "public, max-age=86400", wantTTL: 24 * time.Hour, wantCacheable: true},
+		{cacheControl: "max-age=0", wantTTL: 0, wantCacheable: false},
+		{cacheControl: "private, no-store", wantTTL: 0, wantCacheable: false},
+	}
+	for _, tt := range tests {
+		ttl, cacheable := avatarTTL(tt.cacheControl)
+		assert.Equal(t, tt.wantTTL, ttl, tt.cacheControl)
+		assert.Equal(t, tt.wantCacheable, cacheable, tt.cacheControl)
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import "net/http"
+
+// BareCallbackBehavior is the response of `RedirectHandler` to a callback that can't complete a
+// login: a request without the OAuth2 callback parameters, or a callback with a code that was
+// already used.
+type BareCallbackBehavior int
+
+const (
+	// BareCallbackError responds with an error. It is the default.
+	BareCallbackError BareCallbackBehavior = iota
+	// BareCallbackLogin starts the login flow, unless the user already has a session, in which
+	// case the user is redirected to the application.
+	BareCallbackLogin
+	// BareCallbackHome redirects the user to the application, where unauthenticated users are
+	// redirected to login by `Authenticate`.
+	BareCallbackHome
+)
+
+// bareCallback returns whether the request to the RedirectHandler has none of the callback
+// parameters.
+func bareCallback(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	q := r.URL.Query()
+	return q.Get("code") == "" && q.Get("state") == "" && q.Get("error") == ""
+}
+
+// bareCallback responds to a callback that can't complete a login according to
+// BareCallbackBehavior. The redirect path is the path from the callback state, if known. The
+// state is not verified at this point, therefore only local paths are followed.
+func (a *Auth) bareCallback(w http.ResponseWriter, r *http.Request, redirectPath string) {
+	redirectPath = localPath(redirectPath)
+	switch a.config().BareCallbackBehavior {
+	case BareCallbackLogin:
+		if t, err := a.getCookie(r); err == nil && t != nil {
+			a.logr(r.Context(), "Callback can't complete a login, redirect the logged in user to %q", redirectPath)
+			http.Redirect(w, r, redirectPath, http.StatusSeeOther)
+			return
+		}
+		a.logr(r.Context(), "Callback can't complete a login, start login")
+		a.login(w, r, redirectPath, false)
+	case BareCallbackHome:
+		a.logr(r.Context(), "Callback can't complete a login, redirect to %q", redirectPath)
+		http.Redirect(w, r, redirectPath, http.StatusSeeOther)
+	default:
+		a.logr(r.Context(), "Callback without authorization code")
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+	}
+}
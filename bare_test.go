@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestBareCallback(t *testing.T) {
+	t.Parallel()
+
+	// The provider rejects the code, as it does for a code that was already used.
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+	}))
+	defer s.Close()
+
+	session := newTokenCookie(t, &token{Token: &oauth2.Token{}, IDToken: "id token"})
+
+	tests := []struct {
+		name         string
+		behavior     BareCallbackBehavior
+		target       string
+		session      bool
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "bare error", behavior: BareCallbackError, target: "/auth", wantStatus: http.StatusBadRequest},
+		{name: "bare login", behavior: BareCallbackLogin, target: "/auth", wantStatus: http.StatusTemporaryRedirect, wantLocation: s.URL + "/auth?"},
+		{name: "bare login with session", behavior: BareCallbackLogin, target: "/auth", session: true, wantStatus: http.StatusSeeOther, wantLocation: "/"},
+		{name: "bare home", behavior: BareCallbackHome, target: "/auth", wantStatus: http.StatusSeeOther, wantLocation: "/"},
+		{name: "stale code error", behavior: BareCallbackError, target: "/auth?code=used&state=/app", session: true, wantStatus: http.StatusUnauthorized},
+		{name: "stale code login", behavior: BareCallbackLogin, target: "/auth?code=used&state=/app", wantStatus: http.StatusTemporaryRedirect, wantLocation: s.URL + "/auth?"},
+		{name: "stale code login with session", behavior: BareCallbackLogin, target: "/auth?code=used&state=/app", session: true, wantStatus: http.StatusSeeOther, wantLocation: "/app"},
+		{name: "stale code home", behavior: BareCallbackHome, target: "/auth?code=used&state=/app", wantStatus: http.StatusSeeOther, wantLocation: "/app"},
+		{name: "stale code home with absolute URL state", behavior: BareCallbackHome, target: "/auth?code=used&state=https://evil.example", wantStatus: http.StatusSeeOther, wantLocation: "/"},
+		{name: "stale code home with network path state", behavior: BareCallbackHome, target: "/auth?code=used&state=//evil.example", wantStatus: http.StatusSeeOther, wantLocation: "/"},
+		{name: "stale code login with session and absolute URL state", behavior: BareCallbackLogin, target: "/auth?code=used&state=https://evil.example", session: true, wantStatus: http.StatusSeeOther, wantLocation: "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(context.Background(), Config{
+				Config: oauth2.Config{
+					ClientID:    "client1",
+					RedirectURL: "https://example.com/auth",
+					Endpoint:    oauth2.Endpoint{AuthURL: s.URL + "/auth", TokenURL: s.URL + "/token", AuthStyle: oauth2.AuthStyleInParams},
+				},
+				BareCallbackBehavior: tt.behavior,
+				Log:                  t.Logf,
+			})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.session {
+				req.AddCookie(session)
+			}
+			rec := httptest.NewRecorder()
+			a.RedirectHandler().ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus == http.StatusSeeOther {
+				assert.Equal(t, tt.wantLocation, rec.Header().Get("Location"))
+			} else {
+				assert.True(t, strings.HasPrefix(rec.Header().Get("Location"), tt.wantLocation), rec.Header().Get("Location"))
+			}
+		})
+	}
+}
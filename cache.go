@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/api/idtoken"
+)
+
+// validationCache is an LRU cache of ID token validation results, keyed by the token hash. Entries
+// are kept until the token expires, or until they are evicted by newer entries.
+type validationCache struct {
+	size    int
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	// order holds the entries from the most recently used to the least recently used.
+	order *list.List
+}
+
+type validationEntry struct {
+	key     [sha256.Size]byte
+	payload *idtoken.Payload
+	expires time.Time
+}
+
+func newValidationCache(size int) *validationCache {
+	return &validationCache{
+		size:    size,
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the cached payload of a valid ID token.
+func (c *validationCache) get(idToken string, now time.Time) (*idtoken.Payload, bool) {
+	key := sha256.Sum256([]byte(idToken))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*validationEntry)
+	if !now.Before(entry.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return entry.payload, true
+}
+
+// add caches the payload of a validated ID token until the token expires.
+func (c *validationCache) add(idToken string, payload *idtoken.Payload) {
+	key := sha256.Sum256([]byte(idToken))
+	entry := &validationEntry{key: key, payload: payload, expires: time.Unix(payload.Expires, 0)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// purge removes all the entries.
+func (c *validationCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[[sha256.Size]byte]*list.Element)
+	c.order.Init()
+}
+
+func (c *validationCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*validationEntry).key)
+}
+
+// validateCached validates an ID token of a session, using the validation cache if it is enabled.
+func (a *Auth) validateCached(r *http.Request, idToken string) (*idtoken.Payload, error) {
+	if a.validations == nil {
+		return a.validate(r.Context(), idToken)
+	}
+	if payload, ok := a.validations.get(idToken, a.now()); ok {
+		return payload, nil
+	}
+	payload, err := a.validate(r.Context(), idToken)
+	if err != nil {
+		return nil, err
+	}
+	a.validations.add(idToken, payload)
+	return payload, nil
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/idtoken"
+)
+
+func TestValidationCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	valid := &idtoken.Payload{Expires: now.Add(time.Hour).Unix()}
+	c := newValidationCache(2)
+
+	c.add("token1", valid)
+	c.add("token2", valid)
+	_, ok := c.get("token1", now)
+	require.True(t, ok)
+
+	// Adding a third token evicts the least recently used token.
+	c.add("token3", valid)
+	_, ok = c.get("token2", now)
+	assert.False(t, ok, "least recently used token should be evicted")
+	_, ok = c.get("token1", now)
+	assert.True(t, ok)
+	_, ok = c.get("token3", now)
+	assert.True(t, ok)
+
+	// Tokens are not served after they expire.
+	_, ok = c.get("token1", now.Add(2*time.Hour))
+	assert.False(t, ok, "expired token should not be served")
+	assert.Equal(t, 1, c.order.Len())
+
+	c.purge()
+	_, ok = c.get("token3", now)
+	assert.False(t, ok)
+}
+
+func BenchmarkValidateCached(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		size int
+	}{
+		{name: "no cache", size: 0},
+		{name: "cache", size: 100},
+	}
+	for _, bb := range benchmarks {
+		b.Run(bb.name, func(b *testing.B) {
+			privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 2048)
+			require.NoError(b, err)
+			privateKeyCert := newCert(privateKey, "keyid")
+
+			a, err := New(context.Background(), Config{
+				Config:              oauth2.Config{ClientID: "client1"},
+				ValidationCacheSize: bb.size,
+				Client:              fakeClient(b, certResp{Keys: []cert{privateKeyCert}}),
+			})
+			require.NoError(b, err)
+
+			idToken := genSignedToken(b, privateKeyCert.KID, privateKey, "client1", "email@example.com", "John")
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := a.validateCached(req, idToken)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
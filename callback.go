@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// requestOrigin returns the scheme and the host of the request. When trustForwarded is set, they
+// are taken from the X-Forwarded-Proto and X-Forwarded-Host headers, if present.
+func requestOrigin(r *http.Request, trustForwarded bool) (scheme, host string) {
+	scheme, host = "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if !trustForwarded {
+		return scheme, host
+	}
+	if proto := firstHeaderValue(r, "X-Forwarded-Proto"); proto != "" {
+		scheme = strings.ToLower(proto)
+	}
+	if forwardedHost := firstHeaderValue(r, "X-Forwarded-Host"); forwardedHost != "" {
+		host = forwardedHost
+	}
+	return scheme, host
+}
+
+// firstHeaderValue returns the first value of a comma separated header, that was set by the
+// proxy closest to the client.
+func firstHeaderValue(r *http.Request, header string) string {
+	return strings.TrimSpace(strings.SplitN(r.Header.Get(header), ",", 2)[0])
+}
+
+// canonicalCallback returns the URL on the scheme and host of the redirect URL that the callback
+// request should be redirected to, and whether the callback was received on another scheme or
+// host.
+func canonicalCallback(r *http.Request, redirectURL string, trustForwarded bool) (string, bool) {
+	want, err := url.Parse(redirectURL)
+	if err != nil || want.Host == "" {
+		return "", false
+	}
+	scheme, host := requestOrigin(r, trustForwarded)
+	hostname, port := splitHostPort(host)
+	wantHostname, wantPort := splitHostPort(want.Host)
+	if scheme == want.Scheme && canonicalHost(scheme, hostname, port) == canonicalHost(want.Scheme, wantHostname, wantPort) {
+		return "", false
+	}
+	u := *r.URL
+	u.Scheme = want.Scheme
+	u.Host = want.Host
+	return u.String(), true
+}
+
+// RedirectURLFromRequest returns the external URL of the callback path on the scheme and host
+// that the request was sent to. It can be used to compute `Config.RedirectURL` from a request,
+// instead of formatting it from the server address. The port is omitted when it is the default
+// port of the scheme, and IPv6 hosts are enclosed in brackets.
+//
+// When trustForwarded is set, the scheme, host and port are taken from the X-Forwarded-Proto,
+// X-Forwarded-Host and X-Forwarded-Port headers, if present. It should only be set when the
+// server is behind a proxy that sets these headers.
+func RedirectURLFromRequest(r *http.Request, callbackPath string, trustForwarded bool) string {
+	scheme, host := requestOrigin(r, trustForwarded)
+	if r.URL != nil && r.URL.Scheme != "" && !(trustForwarded && r.Header.Get("X-Forwarded-Proto") != "") {
+		// Requests with an absolute URL, such as client requests.
+		scheme = strings.ToLower(r.URL.Scheme)
+	}
+	hostname, port := splitHostPort(host)
+	if trustForwarded {
+		if forwardedPort := firstHeaderValue(r, "X-Forwarded-Port"); forwardedPort != "" {
+			port = forwardedPort
+		}
+	}
+	u := url.URL{Scheme: scheme, Host: canonicalHost(scheme, hostname, port), Path: "/" + strings.TrimPrefix(callbackPath, "/")}
+	return u.String()
+}
+
+// canonicalHost returns the lower case host of a URL, without the port when it is the default
+// port of the scheme, and with IPv6 hosts enclosed in brackets.
+func canonicalHost(scheme, hostname, port string) string {
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+	host := strings.ToLower(hostname)
+	if port != "" {
+		return net.JoinHostPort(host, port)
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// splitHostPort splits a host with an optional port, and removes the brackets of IPv6 hosts.
+func splitHostPort(host string) (hostname, port string) {
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		return h, p
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), ""
+}
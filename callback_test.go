@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestCanonicalCallback(t *testing.T) {
+	t.Parallel()
+
+	const redirectURL = "https://example.com/auth"
+	tests := []struct {
+		name          string
+		redirectURL   string
+		target        string
+		tls           bool
+		header        map[string]string
+		trust         bool
+		wantCanonical string
+	}{
+		{
+			name:   "match",
+			target: "https://example.com/auth?code=1",
+			tls:    true,
+		},
+		{
+			name:        "default port in redirect URL",
+			redirectURL: "https://example.com:443/auth",
+			target:      "https://example.com/auth?code=1",
+			tls:         true,
+		},
+		{
+			name:   "default port in request",
+			target: "https://EXAMPLE.com:443/auth?code=1",
+			tls:    true,
+		},
+		{
+			name:          "other port",
+			redirectURL:   "https://example.com:8443/auth",
+			target:        "https://example.com/auth?code=1",
+			tls:           true,
+			wantCanonical: "https://example.com:8443/auth?code=1",
+		},
+		{
+			name:          "http",
+			target:        "http://example.com/auth?code=1&state=%2F",
+			wantCanonical: "https://example.com/auth?code=1&state=%2F",
+		},
+		{
+			name:          "other host",
+			target:        "https://www.example.com/auth?code=1",
+			tls:           true,
+			wantCanonical: "https://example.com/auth?code=1",
+		},
+		{
+			name:   "trusted forwarded headers",
+			target: "http://internal:8080/auth?code=1",
+			header: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.com, proxy"},
+			trust:  true,
+		},
+		{
+			name:          "untrusted forwarded headers",
+			target:        "http://internal:8080/auth?code=1",
+			header:        map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.com"},
+			wantCanonical: "https://example.com/auth?code=1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if !tt.tls {
+				req.TLS = nil
+			} else {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			u := redirectURL
+			if tt.redirectURL != "" {
+				u = tt.redirectURL
+			}
+			canonical, ok := canonicalCallback(req, u, tt.trust)
+			assert.Equal(t, tt.wantCanonical != "", ok)
+			assert.Equal(t, tt.wantCanonical, canonical)
+		})
+	}
+
+	// The redirect handler redirects to the canonical URL before exchanging the code.
+	a, err := New(context.Background(), Config{
+		Config:            oauth2.Config{ClientID: "client1", RedirectURL: redirectURL},
+		CanonicalCallback: true,
+		Log:               t.Logf,
+		Client:            fakeClient(t, certResp{}),
+	})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/auth?code=1&state=%2F", nil)
+	rec := httptest.NewRecorder()
+	a.RedirectHandler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://example.com/auth?code=1&state=%2F", rec.Header().Get("Location"))
+}
+
+func TestRedirectURLFromRequest(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		target string
+		tls    bool
+		header map[string]string
+		trust  bool
+		want   string
+	}{
+		{
+			name:   "http",
+			target: "http://example.com/login",
+			want:   "http://example.com/auth",
+		},
+		{
+			name:   "https",
+			target: "https://example.com/login",
+			tls:    true,
+			want:   "https://example.com/auth",
+		},
+		{
+			name:   "default http port",
+			target: "http://example.com:80/login",
+			want:   "http://example.com/auth",
+		},
+		{
+			name:   "default https port",
+			target: "https://example.com:443/login",
+			tls:    true,
+			want:   "https://example.com/auth",
+		},
+		{
+			name:   "non default port",
+			target: "http://example.com:8080/login",
+			want:   "http://example.com:8080/auth",
+		},
+		{
+			name:   "https on http port",
+			target: "https://example.com:80/login",
+			tls:    true,
+			want:   "https://example.com:80/auth",
+		},
+		{
+			name:   "ipv6",
+			target: "http://[::1]/login",
+			want:   "http://[::1]/auth",
+		},
+		{
+			name:   "ipv6 with port",
+			target: "http://[::1]:8080/login",
+			want:   "http://[::1]:8080/auth",
+		},
+		{
+			name:   "ipv6 with default port",
+			target: "http://[::1]:80/login",
+			want:   "http://[::1]/auth",
+		},
+		{
+			name:   "upper case host",
+			target: "http://Example.COM/login",
+			want:   "http://example.com/auth",
+		},
+		{
+			name:   "forwarded",
+			target: "http://10.0.0.1:8080/login",
+			header: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.com"},
+			trust:  true,
+			want:   "https://example.com/auth",
+		},
+		{
+			name:   "forwarded with port",
+			target: "http://10.0.0.1:8080/login",
+			header: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.com", "X-Forwarded-Port": "443"},
+			trust:  true,
+			want:   "https://example.com/auth",
+		},
+		{
+			name:   "forwarded host with port",
+			target: "http://10.0.0.1/login",
+			header: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.com:8443"},
+			trust:  true,
+			want:   "https://example.com:8443/auth",
+		},
+		{
+			name:   "forwarded list",
+			target: "http://10.0.0.1/login",
+			header: map[string]string{"X-Forwarded-Proto": "https, http", "X-Forwarded-Host": "example.com, proxy"},
+			trust:  true,
+			want:   "https://example.com/auth",
+		},
+		{
+			name:   "untrusted forwarded",
+			target: "http://10.0.0.1/login",
+			header: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.com", "X-Forwarded-Port": "443"},
+			want:   "http://10.0.0.1/auth",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			// Server requests have a relative URL.
+			r.URL.Scheme, r.URL.Host = "", ""
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.want, RedirectURLFromRequest(r, "auth", tt.trust))
+		})
+	}
+
+	t.Run("client request", func(t *testing.T) {
+		r, err := http.NewRequest(http.MethodGet, "https://example.com:443", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/auth", RedirectURLFromRequest(r, "/auth", false))
+	})
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestClockTokenExpiry(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+	issuer := newTestIssuer(t, privateKey, "keyid")
+	defer issuer.Close()
+
+	now := time.Now()
+	a, err := New(context.Background(), Config{
+		Config:         oauth2.Config{ClientID: "client1"},
+		AllowedIssuers: []string{issuer.URL},
+		Clock:          func() time.Time { return now },
+		Log:            t.Logf,
+	})
+	require.NoError(t, err)
+
+	idToken := genSignedClaims(t, "keyid", privateKey, jwt.MapClaims{
+		"iss": issuer.URL,
+		"aud": "client1",
+		"sub": "123",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	})
+
+	_, err = a.validate(context.Background(), idToken)
+	require.NoError(t, err)
+
+	// The token expires according to the clock.
+	now = now.Add(2 * time.Hour)
+	_, err = a.validate(context.Background(), idToken)
+	assert.Error(t, err)
+
+	// A token is not valid before it was issued according to the clock.
+	now = now.Add(-4 * time.Hour)
+	_, err = a.validate(context.Background(), idToken)
+	assert.Error(t, err)
+}
+
+func TestClockCooldown(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	a := &Auth{cfg: &Config{
+		Config:          oauth2.Config{RedirectURL: "https://example.com/auth"},
+		DenialThreshold: 1,
+		DenialCooldown:  time.Minute,
+		Clock:           func() time.Time { return now },
+		Log:             t.Logf,
+	}}
+	creds := &Creds{Subject: "123", Key: "123", Email: "email@example.com"}
+
+	rec := httptest.NewRecorder()
+	a.recordDenial(rec, httptest.NewRequest(http.MethodGet, "/", nil), creds)
+	require.Equal(t, 1, len(rec.Result().Cookies()))
+	assert.Equal(t, now.Add(time.Minute).Unix(), rec.Result().Cookies()[0].Expires.Unix())
+	assert.True(t, a.coolingDown("123"))
+
+	// The cooldown ends when the clock passes it.
+	now = now.Add(2 * time.Minute)
+	assert.False(t, a.coolingDown("123"))
+}
+
+func TestClockRefresh(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+	issuer := newTestIssuer(t, privateKey, "keyid")
+	defer issuer.Close()
+	clock := &fakeClock{now: time.Now()}
+	tokenServer, refreshes := newRotatingTokenServer(t, privateKey, issuer.URL, clock.Now)
+	defer tokenServer.Close()
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{
+			ClientID: "client1",
+			Endpoint: oauth2.Endpoint{AuthURL: tokenServer.URL + "/auth", TokenURL: tokenServer.URL + "/token", AuthStyle: oauth2.AuthStyleInParams},
+		},
+		AllowedIssuers: []string{issuer.URL},
+		Clock:          clock.Now,
+		Log:            t.Logf,
+	})
+	require.NoError(t, err)
+
+	expiry := clock.Now().Add(time.Hour)
+	session := newTokenCookie(t, &token{
+		Token: &oauth2.Token{AccessToken: "access", RefreshToken: "r1", Expiry: expiry},
+		IDToken: genSignedClaims(t, "keyid", privateKey, jwt.MapClaims{
+			"iss": issuer.URL, "aud": "client1", "sub": "user1", "exp": expiry.Unix(),
+		}),
+	})
+	h := a.Authenticate(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(session)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The session is not refreshed before it expires by the clock.
+	rec := serve()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int32(0), atomic.LoadInt32(refreshes))
+
+	// The session is refreshed after it expires by the clock.
+	clock.Add(2 * time.Hour)
+	rec = serve()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(refreshes))
+	require.Equal(t, 1, len(rec.Result().Cookies()))
+	assert.Equal(t, cookieName, rec.Result().Cookies()[0].Name)
+}
+
+// fakeClock is a clock that is moved manually, and is safe for concurrent use.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// cooldownCookieName is the name of the cookie that identifies a denied user when there is
+	// no session.
+	cooldownCookieName = "cooldown"
+
+	defaultDenialCooldown = 15 * time.Minute
+)
+
+// denial tracks the authorization denials of a user.
+type denial struct {
+	// count is the number of denials since first.
+	count int
+	first time.Time
+	// until is the end of the cooldown.
+	until time.Time
+}
+
+// recordDenial records an authorization denial of the user, and starts a cooldown when the user
+// reaches the DenialThreshold.
+func (a *Auth) recordDenial(w http.ResponseWriter, r *http.Request, creds *Creds) {
+	cfg := a.config()
+	if cfg.DenialThreshold <= 0 {
+		return
+	}
+	cooldown := cfg.DenialCooldown
+	if cooldown == 0 {
+		cooldown = defaultDenialCooldown
+	}
+
+	now := a.now()
+	a.denialsMu.Lock()
+	defer a.denialsMu.Unlock()
+	if a.denials == nil {
+		a.denials = make(map[string]*denial)
+	}
+	// Drop the denials of users that are no longer counted or cooling down, such that the
+	// denials of all users don't pile up.
+	for key, d := range a.denials {
+		if now.Sub(d.first) > cooldown && !now.Before(d.until) {
+			delete(a.denials, key)
+		}
+	}
+	d := a.denials[creds.Key]
+	if d == nil || now.Sub(d.first) > cooldown {
+		d = &denial{first: now}
+		a.denials[creds.Key] = d
+	}
+	d.count++
+	if d.count < cfg.DenialThreshold {
+		return
+	}
+
+	d.until = now.Add(cooldown)
+	a.logr(r.Context(), "User %q was denied %d times, start cooldown until %s", creds.Email, d.count, d.until)
+	http.SetCookie(w, &http.Cookie{
+		Name:     cooldownCookieName,
+		Value:    creds.Key,
+		Expires:  d.until,
+		Path:     cfg.Path,
+		Secure:   cfg.cookieSecure(),
+		HttpOnly: true,
+	})
+}
+
+// inCooldown returns the key of the user that sent the request and whether the user is in a
+// denial cooldown.
+func (a *Auth) inCooldown(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(cooldownCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, a.coolingDown(cookie.Value)
+}
+
+// coolingDown returns whether the user with the given key is in a denial cooldown.
+func (a *Auth) coolingDown(key string) bool {
+	a.denialsMu.Lock()
+	defer a.denialsMu.Unlock()
+	d := a.denials[key]
+	return d != nil && a.now().Before(d.until)
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestDenialCooldown(t *testing.T) {
+	t.Parallel()
+
+	a := &Auth{cfg: &Config{
+		Config:              oauth2.Config{RedirectURL: "https://example.com/auth"},
+		DenialThreshold:     2,
+		LoginRedirectStatus: http.StatusTemporaryRedirect,
+		Log:                 t.Logf,
+	}}
+	creds := &Creds{Subject: "123", Key: "123", Email: "email@example.com"}
+
+	// First denial does not start a cooldown.
+	rec := httptest.NewRecorder()
+	a.recordDenial(rec, httptest.NewRequest(http.MethodGet, "/", nil), creds)
+	assert.Equal(t, 0, len(rec.Result().Cookies()))
+
+	// Reaching the threshold starts a cooldown.
+	rec = httptest.NewRecorder()
+	a.recordDenial(rec, httptest.NewRequest(http.MethodGet, "/", nil), creds)
+	require.Equal(t, 1, len(rec.Result().Cookies()))
+	cookie := rec.Result().Cookies()[0]
+
+	// Login during cooldown is forbidden without redirect to the OAuth2 flow.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	a.login(rec, req, "/", false)
+	assert.Equal(t, http.StatusForbidden, rec.Result().StatusCode)
+
+	// Other users can login.
+	rec = httptest.NewRecorder()
+	a.login(rec, httptest.NewRequest(http.MethodGet, "/", nil), "/", false)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Result().StatusCode)
+}
+
+func TestDenialsPruned(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	a := &Auth{cfg: &Config{
+		DenialThreshold: 1,
+		DenialCooldown:  time.Minute,
+		Clock:           func() time.Time { return now },
+		Log:             t.Logf,
+	}}
+	deny := func(key string) {
+		a.recordDenial(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), &Creds{Key: key})
+	}
+
+	deny("1")
+	deny("2")
+	assert.Len(t, a.denials, 2)
+
+	// Denials whose cooldown ended are dropped when another user is denied.
+	now = now.Add(2 * time.Minute)
+	deny("3")
+	assert.Len(t, a.denials, 1)
+	assert.True(t, a.coolingDown("3"))
+}
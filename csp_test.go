@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestCSPNonce(t *testing.T) {
+	t.Parallel()
+
+	a, err := New(context.Background(), Config{Disable: true, CSPNonce: true, Log: t.Logf})
+	require.NoError(t, err)
+
+	var nonces []string
+	h := a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := CSPNonce(r.Context())
+		require.NotEmpty(t, nonce)
+		assert.Contains(t, w.Header().Get("Content-Security-Policy"), "script-src 'nonce-"+nonce+"'")
+		nonces = append(nonces, nonce)
+	}))
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	require.Len(t, nonces, 2)
+	assert.NotEqual(t, nonces[0], nonces[1], "nonce should be generated per request")
+
+	// The policy is also set on the responses of the authentication handlers.
+	a, err = New(context.Background(), Config{
+		Config:   oauth2.Config{ClientID: "client1", RedirectURL: "https://example.org/auth"},
+		CSPNonce: true,
+		Log:      t.Logf,
+		Client:   fakeClient(t, certResp{}),
+	})
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	a.LoginHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Security-Policy"), "script-src 'nonce-")
+
+	// Without the option, no policy is set.
+	a, err = New(context.Background(), Config{Disable: true, Log: t.Logf})
+	require.NoError(t, err)
+	rec = httptest.NewRecorder()
+	a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, CSPNonce(r.Context()))
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Empty(t, rec.Header().Get("Content-Security-Policy"))
+}
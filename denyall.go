@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// SetDenyAll turns the deny all mode on or off, for example during incident response. While it is
+// on, new logins are refused and requests without a session get the Config.MaintenanceHandler
+// response. Existing sessions are honored, unless Config.DenyAllDropSessions is set. The initial
+// mode is Config.DenyAll.
+func (a *Auth) SetDenyAll(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&a.denyAll, v)
+	a.logf("Deny all mode set to %v", on)
+}
+
+// deniesAll returns whether the deny all mode is on.
+func (a *Auth) deniesAll() bool {
+	return atomic.LoadInt32(&a.denyAll) == 1
+}
+
+// maintenance writes the response to requests that are denied by the deny all mode.
+func (a *Auth) maintenance(w http.ResponseWriter, r *http.Request) {
+	if h := a.config().MaintenanceHandler; h != nil {
+		h.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestDenyAll(t *testing.T) {
+	t.Parallel()
+
+	privateKey, privateKeyCert := newTestKey(t)
+	session := newSessionCookie(t, genSignedToken(t, privateKeyCert.KID, privateKey, "client1", "email@example.com", "John"))
+
+	tests := []struct {
+		name              string
+		dropSessions      bool
+		cookie            *http.Cookie
+		wantStatus        int
+		wantClearedCookie bool
+	}{
+		{name: "no session", wantStatus: http.StatusServiceUnavailable},
+		{name: "session honored", cookie: session, wantStatus: http.StatusOK},
+		{name: "session dropped", dropSessions: true, cookie: session, wantStatus: http.StatusServiceUnavailable, wantClearedCookie: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(context.Background(), Config{
+				Config:              oauth2.Config{ClientID: "client1", RedirectURL: "https://example.com/auth"},
+				DenyAll:             true,
+				DenyAllDropSessions: tt.dropSessions,
+				Log:                 t.Logf,
+				Client:              fakeClient(t, certResp{Keys: []cert{privateKeyCert}}),
+			})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			rec := httptest.NewRecorder()
+			a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			cleared := len(rec.Result().Cookies()) == 1 && rec.Result().Cookies()[0].Value == ""
+			assert.Equal(t, tt.wantClearedCookie, cleared)
+
+			// New logins are refused.
+			rec = httptest.NewRecorder()
+			a.RedirectHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth?code=code&state=/", nil))
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			rec = httptest.NewRecorder()
+			a.LoginHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+			// Turning the mode off restores the login flow.
+			a.SetDenyAll(false)
+			rec = httptest.NewRecorder()
+			a.Authenticate(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+		})
+	}
+}
+
+func TestMaintenanceHandler(t *testing.T) {
+	t.Parallel()
+
+	a, err := New(context.Background(), Config{
+		Config:  oauth2.Config{RedirectURL: "https://example.com/auth"},
+		DenyAll: true,
+		MaintenanceHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("maintenance"))
+		}),
+	})
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	a.Authenticate(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "maintenance", rec.Body.String())
+}
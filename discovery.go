@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultDiscoveryCacheTTL is the default Config.DiscoveryCacheTTL.
+	defaultDiscoveryCacheTTL = 24 * time.Hour
+	// defaultJWKSCacheTTL is the default Config.JWKSCacheTTL.
+	defaultJWKSCacheTTL = time.Hour
+	// minProviderCacheTTL is the minimum duration that the discovery document and the keys of a
+	// provider are cached, such that the provider is not fetched on every request.
+	minProviderCacheTTL = time.Minute
+)
+
+// discoveryDocument is the OIDC discovery document of an issuer.
+type discoveryDocument struct {
+	Issuer      string `json:"issuer"`
+	JWKSURI     string `json:"jwks_uri"`
+	PAREndpoint string `json:"pushed_authorization_request_endpoint"`
+}
+
+// discoveryEntry is a cached discovery document.
+type discoveryEntry struct {
+	doc     *discoveryDocument
+	expires time.Time
+}
+
+// discover returns the OIDC discovery document of the issuer. The document is cached according to
+// its Cache-Control header, up to DiscoveryCacheTTL.
+func (a *Auth) discover(ctx context.Context, issuer string) (*discoveryDocument, error) {
+	a.discoveriesMu.Lock()
+	entry, ok := a.discoveries[issuer]
+	a.discoveriesMu.Unlock()
+	if ok && a.now().Before(entry.expires) {
+		return entry.doc, nil
+	}
+
+	// Concurrent requests share a single fetch.
+	v, err := a.discovery.do(ctx, "discovery "+issuer, func() (interface{}, error) {
+		ctx, cancel := a.flightContext()
+		defer cancel()
+		doc := &discoveryDocument{}
+		header, err := a.getJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", doc)
+		if err != nil {
+			return nil, err
+		}
+		if doc.Issuer != issuer {
+			return nil, fmt.Errorf("discovery issuer %q does not match", doc.Issuer)
+		}
+		ttl := providerCacheTTL(header.Get("Cache-Control"), a.config().discoveryCacheTTL())
+		a.discoveriesMu.Lock()
+		if a.discoveries == nil {
+			a.discoveries = make(map[string]*discoveryEntry)
+		}
+		a.discoveries[issuer] = &discoveryEntry{doc: doc, expires: a.now().Add(ttl)}
+		a.discoveriesMu.Unlock()
+		return doc, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*discoveryDocument), nil
+}
+
+// providerCacheTTL returns the duration that a provider response with the given Cache-Control
+// header is cached: its max-age, capped by maxTTL, and not less than minProviderCacheTTL.
+// Responses that must not be stored are cached for the minimum duration.
+func providerCacheTTL(cacheControl string, maxTTL time.Duration) time.Duration {
+	ttl, cacheable := cacheControlTTL(cacheControl, maxTTL)
+	switch {
+	case !cacheable || ttl < minProviderCacheTTL:
+		return minProviderCacheTTL
+	case ttl > maxTTL:
+		return maxTTL
+	default:
+		return ttl
+	}
+}
+
+// discoveryCacheTTL returns DiscoveryCacheTTL, or its default.
+func (cfg *Config) discoveryCacheTTL() time.Duration {
+	return providerCacheMaxTTL(cfg.DiscoveryCacheTTL, defaultDiscoveryCacheTTL)
+}
+
+// jwksCacheTTL returns JWKSCacheTTL, or its default.
+func (cfg *Config) jwksCacheTTL() time.Duration {
+	return providerCacheMaxTTL(cfg.JWKSCacheTTL, defaultJWKSCacheTTL)
+}
+
+// providerCacheMaxTTL returns the configured maximum cache duration, or the default when it is
+// not set, and not less than minProviderCacheTTL.
+func providerCacheMaxTTL(ttl, defaultTTL time.Duration) time.Duration {
+	switch {
+	case ttl == 0:
+		return defaultTTL
+	case ttl < minProviderCacheTTL:
+		return minProviderCacheTTL
+	default:
+		return ttl
+	}
+}
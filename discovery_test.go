@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestProviderCacheTTL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		cacheControl string
+		maxTTL       time.Duration
+		want         time.Duration
+	}{
+		{cacheControl: "", maxTTL: time.Hour, want: time.Hour},
+		{cacheControl: "public, max-age=600", maxTTL: time.Hour, want: 10 * time.Minute},
+		{cacheControl: "max-age=86400", maxTTL: time.Hour, want: time.Hour},
+		{cacheControl: "max-age=10", maxTTL: time.Hour, want: minProviderCacheTTL},
+		{cacheControl: "max-age=0", maxTTL: time.Hour, want: minProviderCacheTTL},
+		{cacheControl: "no-store", maxTTL: time.Hour, want: minProviderCacheTTL},
+		{cacheControl: "no-cache", maxTTL: time.Hour, want: minProviderCacheTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cacheControl, func(t *testing.T) {
+			assert.Equal(t, tt.want, providerCacheTTL(tt.cacheControl, tt.maxTTL))
+		})
+	}
+}
+
+func TestProviderCacheMaxTTL(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, defaultDiscoveryCacheTTL, (&Config{}).discoveryCacheTTL())
+	assert.Equal(t, defaultJWKSCacheTTL, (&Config{}).jwksCacheTTL())
+	assert.Equal(t, 5*time.Minute, (&Config{DiscoveryCacheTTL: 5 * time.Minute}).discoveryCacheTTL())
+	assert.Equal(t, minProviderCacheTTL, (&Config{JWKSCacheTTL: time.Second}).jwksCacheTTL())
+}
+
+func TestDiscoveryCache(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+
+	issuer := newTestIssuer(t, privateKey, "keyid")
+	defer issuer.Close()
+
+	var discoveries, jwks int32
+	issuerHandler := issuer.Config.Handler
+	issuer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			atomic.AddInt32(&discoveries, 1)
+			w.Header().Set("Cache-Control", "max-age=86400")
+		case "/jwks":
+			atomic.AddInt32(&jwks, 1)
+			w.Header().Set("Cache-Control", "max-age=600")
+		}
+		issuerHandler.ServeHTTP(w, r)
+	})
+
+	a, err := New(context.Background(), Config{
+		Config:            oauth2.Config{ClientID: "client1"},
+		AllowedIssuers:    []string{issuer.URL},
+		DiscoveryCacheTTL: 2 * time.Hour,
+		Log:               t.Logf,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = a.issuerKey(ctx, issuer.URL, "keyid")
+	require.NoError(t, err)
+	_, err = a.issuerKey(ctx, issuer.URL, "keyid")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&discoveries))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&jwks))
+
+	// The discovery TTL is capped by DiscoveryCacheTTL, and the keys TTL by the response max-age.
+	a.discoveriesMu.Lock()
+	assert.InDelta(t, time.Now().Add(2*time.Hour).Unix(), a.discoveries[issuer.URL].expires.Unix(), 5)
+	a.discoveriesMu.Unlock()
+	a.keySetsMu.Lock()
+	assert.InDelta(t, time.Now().Add(10*time.Minute).Unix(), a.keySets[issuer.URL].expires.Unix(), 5)
+	// Expire the keys.
+	a.keySets[issuer.URL].expires = time.Now().Add(-time.Second)
+	a.keySetsMu.Unlock()
+
+	// Expired keys are fetched again, with the cached discovery document.
+	_, err = a.issuerKey(ctx, issuer.URL, "keyid")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&discoveries))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&jwks))
+}
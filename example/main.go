@@ -69,9 +69,16 @@ func main() {
 	log.Printf("Redirect URL: %v", config.Config.RedirectURL)
 	log.Printf("Authorized user email: %q", *authorized)
 
+	// The authentication routes are mounted on their own mux, and don't depend on the application
+	// handler. This keeps login and logout working even if the application handler fails.
+	authMux := http.NewServeMux()
+	a.AuthRoutes(authMux, "/login", "/logout")
+
 	mux := http.NewServeMux()
 	mux.Handle("/", a.Authenticate(http.HandlerFunc(handler)))
-	mux.Handle("/"+*callbackPath, a.RedirectHandler())
+	mux.Handle("/"+*callbackPath, authMux)
+	mux.Handle("/login", authMux)
+	mux.Handle("/logout", authMux)
 
 	addr := fmt.Sprintf(":%d", *port)
 	errC, err := run(mux, addr)
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultFlightTimeout bounds a shared call when the client has no timeout.
+const defaultFlightTimeout = 30 * time.Second
+
+// flightGroup deduplicates concurrent calls with the same key, such that concurrent callers
+// share the result of a single call. It is used to fetch provider metadata lazily, without a
+// burst of concurrent first requests fetching it multiple times.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is an in flight call of a flightGroup.
+type flightCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// do calls fn and returns its result. If there is an in flight call with the same key, it waits
+// for it and returns its result instead. The call runs in the background, such that a caller
+// that stops waiting when its context is done does not fail the call for the other callers.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if !ok {
+		c = &flightCall{done: make(chan struct{})}
+		if g.calls == nil {
+			g.calls = make(map[string]*flightCall)
+		}
+		g.calls[key] = c
+		go func() {
+			c.value, c.err = fn()
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flightContext returns the context of a call that is shared by concurrent requests. It is
+// detached from the request that started the call, such that when that request is canceled the
+// call does not fail for the other requests, and it is bounded by the client timeout.
+func (a *Auth) flightContext() (context.Context, context.CancelFunc) {
+	timeout := a.config().Client.Timeout
+	if timeout <= 0 {
+		timeout = defaultFlightTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestConcurrentDiscovery(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+
+	issuer := newTestIssuer(t, privateKey, "keyid")
+	defer issuer.Close()
+
+	// Count the discovery requests, and delay them such that all the logins are concurrent.
+	var discoveries int32
+	issuerHandler := issuer.Config.Handler
+	issuer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/.well-known/openid-configuration" {
+			atomic.AddInt32(&discoveries, 1)
+			time.Sleep(50 * time.Millisecond)
+		}
+		issuerHandler.ServeHTTP(w, r)
+	})
+
+	a, err := New(context.Background(), Config{
+		Config:         oauth2.Config{ClientID: "client1"},
+		AllowedIssuers: []string{issuer.URL},
+		Log:            t.Logf,
+	})
+	require.NoError(t, err)
+
+	idToken := genSignedClaims(t, "keyid", privateKey, jwt.MapClaims{
+		"iss": issuer.URL,
+		"aud": "client1",
+		"sub": "123",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := a.validate(context.Background(), idToken)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&discoveries))
+}
+
+func TestFlightGroupError(t *testing.T) {
+	t.Parallel()
+
+	var g flightGroup
+	_, err := g.do(context.Background(), "key", func() (interface{}, error) { return nil, assert.AnError })
+	assert.Equal(t, assert.AnError, err)
+
+	// Failed calls are not cached.
+	v, err := g.do(context.Background(), "key", func() (interface{}, error) { return "value", nil })
+	require.NoError(t, err)
+	assert.Equal(t, "value", v)
+}
+
+func TestFlightGroupCanceledCaller(t *testing.T) {
+	t.Parallel()
+
+	var g flightGroup
+	started, release := make(chan struct{}), make(chan struct{})
+	fn := func() (interface{}, error) {
+		close(started)
+		<-release
+		return "value", nil
+	}
+
+	// The first caller stops waiting when its context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := g.do(ctx, "key", fn)
+		errs <- err
+	}()
+	<-started
+	cancel()
+	assert.Equal(t, context.Canceled, <-errs)
+
+	// The call continues for the other callers.
+	values := make(chan interface{}, 1)
+	go func() {
+		v, err := g.do(context.Background(), "key", func() (interface{}, error) { return "other", nil })
+		assert.NoError(t, err)
+		values <- v
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	assert.Equal(t, "value", <-values)
+}
+
+func TestDiscoveryCanceledCaller(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+	issuer := newTestIssuer(t, privateKey, "keyid")
+	defer issuer.Close()
+
+	var discoveries int32
+	started, release := make(chan struct{}), make(chan struct{})
+	issuerHandler := issuer.Config.Handler
+	issuer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/.well-known/openid-configuration" {
+			if atomic.AddInt32(&discoveries, 1) == 1 {
+				close(started)
+			}
+			<-release
+		}
+		issuerHandler.ServeHTTP(w, r)
+	})
+
+	a, err := New(context.Background(), Config{
+		Config:         oauth2.Config{ClientID: "client1"},
+		AllowedIssuers: []string{issuer.URL},
+		Log:            t.Logf,
+	})
+	require.NoError(t, err)
+
+	// The request that started the discovery is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := a.discover(ctx, issuer.URL)
+		errs <- err
+	}()
+	<-started
+	cancel()
+	assert.Error(t, <-errs)
+
+	// A concurrent request gets the discovery document without fetching it again.
+	docs := make(chan *discoveryDocument, 1)
+	go func() {
+		doc, err := a.discover(context.Background(), issuer.URL)
+		assert.NoError(t, err)
+		docs <- doc
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	doc := <-docs
+	require.NotNil(t, doc)
+	assert.Equal(t, issuer.URL, doc.Issuer)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&discoveries))
+}
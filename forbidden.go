@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+// forbiddenTemplate is the page of users that are not allowed, with a link to switch account.
+var forbiddenTemplate = template.Must(template.New("forbidden").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Forbidden</title></head>
+<body>
+<p>You are not allowed to access this page with your current account.</p>
+<p><a href="{{.}}">Switch account</a></p>
+</body>
+</html>
+`))
+
+// SwitchAccountURL returns the URL of the LoginHandler that lets the user choose another account,
+// which replaces the session of the request, and then returns to the request URL. It can be used
+// in custom forbidden pages. It returns an empty string if the LoginHandler was not mounted using
+// `Auth.AuthRoutes`.
+func (a *Auth) SwitchAccountURL(r *http.Request) string {
+	loginPath, _ := a.loginPath.Load().(string)
+	if loginPath == "" {
+		return ""
+	}
+	return loginPath + "?" + url.Values{"prompt": {"select_account"}, "next": {r.URL.RequestURI()}}.Encode()
+}
+
+// forbidden writes the response to users that are not allowed. Browsers get a page with a link to
+// switch account, since otherwise the provider keeps logging in the same account.
+func (a *Auth) forbidden(w http.ResponseWriter, r *http.Request) {
+	switchURL := a.SwitchAccountURL(r)
+	if switchURL == "" || a.config().APIRequest(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	err := forbiddenTemplate.Execute(w, switchURL)
+	if err != nil {
+		a.logr(r.Context(), "Failed writing forbidden page: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestSwitchAccount(t *testing.T) {
+	t.Parallel()
+
+	privateKey, privateKeyCert := newTestKey(t)
+	session := newSessionCookie(t, genSignedToken(t, privateKeyCert.KID, privateKey, "client1", "email@example.com", "John"))
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{ClientID: "client1", RedirectURL: "https://example.com/auth"},
+		Log:    t.Logf,
+		Client: fakeClient(t, certResp{Keys: []cert{privateKeyCert}}),
+	})
+	require.NoError(t, err)
+	h := a.Authenticate(http.NotFoundHandler(), WithAuthorize(func(*Creds) bool { return false }))
+
+	// Without a mounted login handler, there is no link.
+	req := httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.AddCookie(session)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "Switch account")
+
+	mux := http.NewServeMux()
+	a.AuthRoutes(mux, "/login", "/logout")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Switch account")
+	switchURL := a.SwitchAccountURL(req)
+	assert.Equal(t, "/login?next=%2Fpage&prompt=select_account", switchURL)
+
+	// The switch account link lets the user select an account, and keeps the session until the
+	// login completes.
+	req = httptest.NewRequest(http.MethodGet, switchURL, nil)
+	req.AddCookie(session)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, 0, len(rec.Result().Cookies()))
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "select_account consent", loc.Query().Get("prompt"))
+	assert.Equal(t, "/page", loc.Query().Get("state"))
+}
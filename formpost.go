@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// callbackCSRFCookieName is the name of the cookie that binds a form post callback to the
+	// browser that started the login.
+	callbackCSRFCookieName = "callback_csrf"
+	// callbackCSRFTTL is the lifetime of the callback CSRF cookie, the time that a user has to
+	// complete the login at the provider.
+	callbackCSRFTTL = 10 * time.Minute
+	// callbackCSRFBytes is the number of random bytes in a callback CSRF token.
+	callbackCSRFBytes = 16
+	// stateCSRFSeparator separates the CSRF token from the state of a form post callback.
+	stateCSRFSeparator = "."
+)
+
+// formPostState returns the state of a form post login, bound to a new CSRF token that is set in
+// a cookie. The cookie is SameSite=None, since the provider posts the callback cross site.
+func (a *Auth) formPostState(w http.ResponseWriter, state string) (string, error) {
+	b := make([]byte, callbackCSRFBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("generating callback CSRF token: %v", err)
+	}
+	csrf := base64.RawURLEncoding.EncodeToString(b)
+	cfg := a.config()
+	http.SetCookie(w, &http.Cookie{
+		Name:     callbackCSRFCookieName,
+		Value:    csrf,
+		Expires:  a.now().Add(callbackCSRFTTL),
+		MaxAge:   int(callbackCSRFTTL.Seconds()),
+		Path:     cfg.Path,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+	return csrf + stateCSRFSeparator + state, nil
+}
+
+// callbackParams returns the parameters of a callback request. With FormPostCallback, the
+// callback must be posted, and the CSRF token in its state must match the cookie that was set
+// when the login started. The returned state does not include the CSRF token.
+func (a *Auth) callbackParams(w http.ResponseWriter, r *http.Request) (url.Values, error) {
+	cfg := a.config()
+	if !cfg.FormPostCallback {
+		return r.URL.Query(), nil
+	}
+	if r.Method != http.MethodPost {
+		return nil, fmt.Errorf("form post callback was sent with method %s", r.Method)
+	}
+	err := r.ParseForm()
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing callback form: %v", err)
+	}
+
+	params := make(url.Values)
+	for k, v := range r.PostForm {
+		params[k] = v
+	}
+	i := strings.Index(params.Get("state"), stateCSRFSeparator)
+	if i < 0 {
+		return nil, fmt.Errorf("callback state has no CSRF token")
+	}
+	csrf, state := params.Get("state")[:i], params.Get("state")[i+len(stateCSRFSeparator):]
+	cookie, err := r.Cookie(callbackCSRFCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, fmt.Errorf("callback CSRF cookie is missing")
+	}
+	if subtle.ConstantTimeCompare([]byte(csrf), []byte(cookie.Value)) != 1 {
+		return nil, fmt.Errorf("callback CSRF token does not match the cookie")
+	}
+	// The token is used once.
+	http.SetCookie(w, &http.Cookie{
+		Name:     callbackCSRFCookieName,
+		Value:    "",
+		Expires:  a.now(),
+		MaxAge:   -1,
+		Path:     cfg.Path,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+	params.Set("state", state)
+	return params, nil
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestFormPostCallback(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"token_type": "Bearer", "access_token": "access", "id_token": "id token"})
+	}))
+	defer s.Close()
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{
+			ClientID:    "client1",
+			RedirectURL: "https://example.com/auth",
+			Endpoint:    oauth2.Endpoint{AuthURL: s.URL + "/auth", TokenURL: s.URL + "/token"},
+		},
+		FormPostCallback: true,
+		Log:              t.Logf,
+	})
+	require.NoError(t, err)
+
+	// Login binds the state to a CSRF cookie.
+	rec := httptest.NewRecorder()
+	a.LoginHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login?next=/app", nil))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "form_post", location.Query().Get("response_mode"))
+	state := location.Query().Get("state")
+	var csrf *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == callbackCSRFCookieName {
+			csrf = c
+		}
+	}
+	require.NotNil(t, csrf)
+	assert.Equal(t, http.SameSiteNoneMode, csrf.SameSite)
+	assert.True(t, csrf.Secure)
+	assert.Equal(t, csrf.Value+stateCSRFSeparator+"/app", state)
+
+	tests := []struct {
+		name       string
+		method     string
+		state      string
+		cookie     string
+		wantStatus int
+	}{
+		{name: "valid", method: http.MethodPost, state: state, cookie: csrf.Value, wantStatus: http.StatusSeeOther},
+		{name: "missing cookie", method: http.MethodPost, state: state, wantStatus: http.StatusForbidden},
+		{name: "other cookie", method: http.MethodPost, state: state, cookie: "other", wantStatus: http.StatusForbidden},
+		{name: "missing token", method: http.MethodPost, state: "/app", cookie: csrf.Value, wantStatus: http.StatusForbidden},
+		{name: "get", method: http.MethodGet, state: state, cookie: csrf.Value, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"code": {"code"}, "state": {tt.state}}.Encode()
+			var req *http.Request
+			if tt.method == http.MethodPost {
+				req = httptest.NewRequest(http.MethodPost, "/auth", strings.NewReader(form))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(http.MethodGet, "/auth?"+form, nil)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: callbackCSRFCookieName, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			a.RedirectHandler().ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus == http.StatusSeeOther {
+				assert.Equal(t, "/app", rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestFormPostCallbackRequiresSecureCookies(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(context.Background(), Config{
+		Config:           oauth2.Config{ClientID: "client1", RedirectURL: "http://localhost/auth"},
+		FormPostCallback: true,
+		Unsecure:         true,
+		Log:              t.Logf,
+	})
+	assert.Error(t, err)
+}
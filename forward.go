@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultForwardHeaders is the default Config.ForwardHeaders.
+var defaultForwardHeaders = map[string]string{
+	"sub":   "X-Auth-Subject",
+	"email": "X-Auth-Email",
+}
+
+// VerifyHandler returns an authenticated handler for forward authentication, where a reverse
+// proxy verifies each request with this handler before passing it to the upstream. It responds
+// to authenticated requests with an empty OK response that has the identity headers of
+// Config.ForwardHeaders, that the proxy should copy to the upstream request. Headers of missing
+// claims are set with an empty value, such that a proxy that copies them overwrites the headers
+// that were sent by the client. A proxy that copies only present headers must strip the client
+// copies of these headers itself. Other requests get the `Authenticate` response.
+//
+// Requests without a session are redirected to login when the proxy sets the X-Forwarded-Uri
+// header, as Traefik and Caddy do, and the user returns to that URI after login. Otherwise, such
+// as with nginx auth_request, that can't pass a redirect to the client, they get an unauthorized
+// response. If `AuthRoutes` mounted the `LoginHandler`, the response has the X-Auth-Login-URL
+// header with the login URL, that the proxy can redirect the client to. The URI is taken from
+// the X-Original-URI header, if the proxy sets it.
+func (a *Auth) VerifyHandler(opts ...Option) http.Handler {
+	opts = append([]Option{func(o *options) { o.forward = true }}, opts...)
+	return a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		a.setForwardHeaders(w.Header(), r)
+		w.WriteHeader(http.StatusOK)
+	}), opts...)
+}
+
+// forwardedURI returns the URI of the request that a forward authentication request verifies.
+func forwardedURI(r *http.Request) string {
+	if uri := r.Header.Get("X-Forwarded-Uri"); uri != "" {
+		return localPath(uri)
+	}
+	return localPath(r.Header.Get("X-Original-URI"))
+}
+
+// forwardRequireLogin responds to a forward authentication request without a session. A redirect
+// to login is only returned to proxies that pass it to the client.
+func (a *Auth) forwardRequireLogin(w http.ResponseWriter, r *http.Request, redirectPath string) {
+	if r.Header.Get("X-Forwarded-Uri") != "" {
+		a.requireLogin(w, r, redirectPath)
+		return
+	}
+	if loginPath, _ := a.loginPath.Load().(string); loginPath != "" {
+		w.Header().Set("X-Auth-Login-URL", loginPath+"?"+url.Values{"next": {redirectPath}}.Encode())
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// setForwardHeaders sets the identity headers of the authenticated user of the request.
+func (a *Auth) setForwardHeaders(header http.Header, r *http.Request) {
+	claims, _ := r.Context().Value(claimsKey).(map[string]interface{})
+	forwardHeaders := a.config().ForwardHeaders
+	if forwardHeaders == nil {
+		forwardHeaders = defaultForwardHeaders
+	}
+	for claim, name := range forwardHeaders {
+		v, ok := claims[claim]
+		if !ok {
+			// An empty value overwrites a header with the same name that was sent by the client,
+			// when the proxy copies it to the upstream request.
+			header.Set(name, "")
+			continue
+		}
+		header.Set(name, headerValue(v))
+	}
+}
+
+// headerValue formats a claim value as a header value. Lists are joined with commas, and control
+// characters are removed, to prevent header injection from the claim content.
+func headerValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			values = append(values, headerValue(e))
+		}
+		s = strings.Join(values, ",")
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		s = fmt.Sprint(v)
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
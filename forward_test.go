@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestVerifyHandler(t *testing.T) {
+	t.Parallel()
+
+	privateKey, privateKeyCert := newTestKey(t)
+	idToken := genSignedClaims(t, privateKeyCert.KID, privateKey, jwt.MapClaims{
+		"aud":    "client1",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"sub":    "123",
+		"email":  "email@example.com",
+		"name":   "John\r\nX-Admin: true",
+		"groups": []string{"admins", "users"},
+	})
+	session := newSessionCookie(t, idToken)
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{ClientID: "client1", RedirectURL: "https://example.com/auth"},
+		ForwardHeaders: map[string]string{
+			"sub":     "X-User-Id",
+			"name":    "X-User-Name",
+			"groups":  "X-User-Groups",
+			"missing": "X-Missing",
+		},
+		Log:    t.Logf,
+		Client: fakeClient(t, certResp{Keys: []cert{privateKeyCert}}),
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.AddCookie(session)
+	rec := httptest.NewRecorder()
+	a.VerifyHandler().ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "123", rec.Header().Get("X-User-Id"))
+	assert.Equal(t, "JohnX-Admin: true", rec.Header().Get("X-User-Name"))
+	assert.Equal(t, "admins,users", rec.Header().Get("X-User-Groups"))
+	assert.Equal(t, []string{""}, rec.Header()["X-Missing"])
+	assert.NotContains(t, rec.Header(), "X-Auth-Email")
+
+	// Unauthenticated requests are not verified. Proxies that pass redirects to the client are
+	// redirected to login, that returns to the forwarded URI.
+	req = httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.Header.Set("X-Forwarded-Uri", "/app?x=1")
+	rec = httptest.NewRecorder()
+	a.VerifyHandler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.NotContains(t, rec.Header(), "X-User-Id")
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/app?x=1", location.Query().Get("state"))
+
+	// Other proxies get an unauthorized response.
+	req = httptest.NewRequest(http.MethodGet, "/verify", nil)
+	req.Header.Set("X-Original-URI", "/app?x=1")
+	rec = httptest.NewRecorder()
+	a.VerifyHandler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.NotContains(t, rec.Header(), "X-User-Id")
+	assert.NotContains(t, rec.Header(), "X-Auth-Login-Url")
+
+	// With the login handler mounted, the response has the login URL.
+	a.AuthRoutes(http.NewServeMux(), "/login", "/logout")
+	rec = httptest.NewRecorder()
+	a.VerifyHandler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "/login?next=%2Fapp%3Fx%3D1", rec.Header().Get("X-Auth-Login-URL"))
+}
+
+func TestHeaderValue(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "a", headerValue("a\n"))
+	assert.Equal(t, "1234567890", headerValue(float64(1234567890)))
+	assert.Equal(t, "true", headerValue(true))
+	assert.Equal(t, "a,b", headerValue([]interface{}{"a", "b"}))
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	defaultIdentityTTL    = 5 * time.Minute
+	defaultIdentityHeader = "X-Auth-Identity"
+)
+
+// IdentityJWT configures a short lived JWT of the user identity, that is issued on authenticated
+// responses. It is useful for an edge or CDN that authorizes cached content without a round trip
+// to the origin.
+//
+// The JWT is signed with RS256 and holds the "sub", "email", "name", "iat" and "exp" claims, in
+// addition to the claims returned by Claims. The edge should verify it as follows:
+//
+// - The "alg" header is "RS256" and the "kid" header is KeyID.
+//
+// - The signature is valid for the public key of Key.
+//
+// - The "exp" claim is in the future, and the "iss" claim is Issuer, if set.
+type IdentityJWT struct {
+	// Key is the private key that signs the JWT.
+	Key *rsa.PrivateKey
+	// KeyID is set as the "kid" header of the JWT.
+	KeyID string
+	// Issuer, if set, is set as the "iss" claim of the JWT.
+	Issuer string
+	// TTL is the lifetime of the JWT. Defaults to 5 minutes.
+	TTL time.Duration
+	// Header is the name of the response header that holds the JWT. Defaults to
+	// "X-Auth-Identity".
+	Header string
+	// Cookie, if set, is the name of a cookie that holds the JWT. The cookie is renewed when it
+	// is about to expire or when it holds another user, and it is cleared with the session.
+	Cookie string
+	// Claims, if set, returns additional claims for the user.
+	Claims func(*Creds) map[string]interface{}
+	// PreviousKeys are public keys by key ID, that are published by `Auth.JWKSHandler` in
+	// addition to the public key of Key. When rotating Key using `Auth.Reload`, the previous key
+	// should be kept here until the JWTs that it signed expire.
+	PreviousKeys map[string]*rsa.PublicKey
+}
+
+// issueIdentity sets the identity JWT of the user in the response.
+func (a *Auth) issueIdentity(w http.ResponseWriter, r *http.Request, creds *Creds) error {
+	cfg := a.config()
+	identity := cfg.IssueIdentityJWT
+
+	ttl := identity.TTL
+	if ttl == 0 {
+		ttl = defaultIdentityTTL
+	}
+	header := identity.Header
+	if header == "" {
+		header = defaultIdentityHeader
+	}
+
+	now := a.now()
+	claims := jwt.MapClaims{}
+	if identity.Claims != nil {
+		for k, v := range identity.Claims(creds) {
+			claims[k] = v
+		}
+	}
+	claims["sub"] = creds.Subject
+	claims["email"] = creds.Email
+	claims["name"] = creds.Name
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+	if identity.Issuer != "" {
+		claims["iss"] = identity.Issuer
+	}
+
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if identity.KeyID != "" {
+		t.Header["kid"] = identity.KeyID
+	}
+	signed, err := t.SignedString(identity.Key)
+	if err != nil {
+		return err
+	}
+	w.Header().Set(header, signed)
+
+	if identity.Cookie == "" || !identityCookieStale(r, identity.Cookie, creds.Subject, now, ttl/2) {
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:    identity.Cookie,
+		Value:   signed,
+		Expires: now.Add(ttl),
+		Path:    cfg.Path,
+		Secure:  cfg.cookieSecure(),
+	})
+	return nil
+}
+
+// identityCookieStale returns whether the identity cookie is missing from the request, holds
+// another subject, for example after switching account or impersonating, or expires within the
+// given duration from now.
+func identityCookieStale(r *http.Request, name, subject string, now time.Time, within time.Duration) bool {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return true
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+		Exp     int64  `json:"exp"`
+	}
+	err = decodeTokenSegment(cookie.Value, 1, &claims)
+	if err != nil {
+		return true
+	}
+	return claims.Subject != subject || time.Unix(claims.Exp, 0).Before(now.Add(within))
+}
+
+// jwk is a JSON Web Key of an RSA public key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func newJWK(keyID string, key *rsa.PublicKey) jwk {
+	return jwk{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: keyID,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// JWKSHandler serves the JSON Web Key Set of the keys that sign the identity JWTs of
+// Config.IssueIdentityJWT, such that downstream services can verify them. The set holds the
+// public key of the current signing key and the PreviousKeys, and follows the configuration on
+// reload. It responds with not found when identity JWTs are not issued.
+func (a *Auth) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := a.config().IssueIdentityJWT
+		if identity == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		keys := []jwk{newJWK(identity.KeyID, &identity.Key.PublicKey)}
+		var previous []string
+		for keyID := range identity.PreviousKeys {
+			previous = append(previous, keyID)
+		}
+		sort.Strings(previous)
+		for _, keyID := range previous {
+			keys = append(keys, newJWK(keyID, identity.PreviousKeys[keyID]))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		err := json.NewEncoder(w).Encode(struct {
+			Keys []jwk `json:"keys"`
+		}{Keys: keys})
+		if err != nil {
+			a.logr(r.Context(), "Failed writing JWKS: %v", err)
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueIdentity(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+
+	a := &Auth{cfg: &Config{IssueIdentityJWT: &IdentityJWT{
+		Key:    privateKey,
+		KeyID:  "keyid",
+		Issuer: "https://example.com",
+		Cookie: "identity",
+		Claims: func(c *Creds) map[string]interface{} { return map[string]interface{}{"role": "admin"} },
+	}}}
+	creds := &Creds{Subject: "123", Email: "email@example.com", Name: "John"}
+
+	rec := httptest.NewRecorder()
+	err = a.issueIdentity(rec, httptest.NewRequest(http.MethodGet, "/", nil), creds)
+	require.NoError(t, err)
+
+	// Verify the JWT as the edge would.
+	signed := rec.Result().Header.Get(defaultIdentityHeader)
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(signed, claims, func(token *jwt.Token) (interface{}, error) {
+		assert.Equal(t, "RS256", token.Header["alg"])
+		assert.Equal(t, "keyid", token.Header["kid"])
+		return &privateKey.PublicKey, nil
+	})
+	require.NoError(t, err)
+	assert.True(t, parsed.Valid)
+	assert.True(t, claims.VerifyIssuer("https://example.com", true))
+	assert.Equal(t, "123", claims["sub"])
+	assert.Equal(t, creds.Email, claims["email"])
+	assert.Equal(t, creds.Name, claims["name"])
+	assert.Equal(t, "admin", claims["role"])
+	assert.InDelta(t, time.Now().Add(defaultIdentityTTL).Unix(), claims["exp"], 5)
+
+	// The cookie is set when missing.
+	require.Equal(t, 1, len(rec.Result().Cookies()))
+	cookie := rec.Result().Cookies()[0]
+	assert.Equal(t, "identity", cookie.Name)
+	assert.Equal(t, signed, cookie.Value)
+
+	// The cookie is not renewed when it is fresh.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	err = a.issueIdentity(rec, req, creds)
+	require.NoError(t, err)
+	assert.NotEmpty(t, rec.Result().Header.Get(defaultIdentityHeader))
+	assert.Equal(t, 0, len(rec.Result().Cookies()))
+
+	// The cookie is reissued when it holds another user.
+	other := &Creds{Subject: "456", Email: "other@example.com", Name: "Jane"}
+	rec = httptest.NewRecorder()
+	err = a.issueIdentity(rec, req, other)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rec.Result().Cookies()))
+	claims = jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rec.Result().Cookies()[0].Value, claims, func(*jwt.Token) (interface{}, error) {
+		return &privateKey.PublicKey, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "456", claims["sub"])
+
+	// The cookie is cleared with the session.
+	rec = httptest.NewRecorder()
+	a.clearCookie(rec)
+	var cleared bool
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "identity" {
+			cleared = c.Value == ""
+		}
+	}
+	assert.True(t, cleared)
+}
+
+func TestJWKSHandler(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+	previousKey, err := rsa.GenerateKey(rand.New(rand.NewSource(1)), 1024)
+	require.NoError(t, err)
+
+	a := &Auth{cfg: &Config{IssueIdentityJWT: &IdentityJWT{
+		Key:          privateKey,
+		KeyID:        "current",
+		PreviousKeys: map[string]*rsa.PublicKey{"previous": &previousKey.PublicKey},
+	}}}
+	rec := httptest.NewRecorder()
+	err = a.issueIdentity(rec, httptest.NewRequest(http.MethodGet, "/", nil), &Creds{Subject: "123"})
+	require.NoError(t, err)
+	signed := rec.Result().Header.Get(defaultIdentityHeader)
+
+	rec = httptest.NewRecorder()
+	a.JWKSHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jwks", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.NewDecoder(rec.Body).Decode(&jwks)
+	require.NoError(t, err)
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "previous", jwks.Keys[1].Kid)
+
+	// Verify the JWT with the published key that matches its key ID, as a downstream service would.
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		assert.Equal(t, "RSA", k.Kty)
+		assert.Equal(t, "RS256", k.Alg)
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		require.NoError(t, err)
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		require.NoError(t, err)
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	_, err = jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+		return keys[token.Header["kid"].(string)], nil
+	})
+	assert.NoError(t, err)
+
+	a = &Auth{cfg: &Config{}}
+	rec = httptest.NewRecorder()
+	a.JWKSHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jwks", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// impersonateCookieName is the name of the cookie that holds the impersonated user subject.
+const impersonateCookieName = "impersonate"
+
+// Impersonate makes the user that sent the request view the application as the user with the
+// given subject, in the following authenticated requests. It should be called inside an
+// authenticated `http.Handler`, and is allowed only for users that pass `Config.CanImpersonate`.
+// Calling it with an empty subject stops the impersonation.
+//
+// During impersonation, `User` returns credentials that hold only the Subject of the
+// impersonated user, which is also used as its Key since the claims of the impersonated user are
+// not available, and the Name from `Config.DisplayNameFunc`. `Actor` returns the credentials of
+// the real user. Every impersonated
+// request is logged and audited.
+func (a *Auth) Impersonate(w http.ResponseWriter, r *http.Request, targetSub string) error {
+	cfg := a.config()
+	actor := Actor(r.Context())
+	if actor == nil {
+		actor = User(r.Context())
+	}
+	if actor == nil {
+		return fmt.Errorf("impersonation requires an authenticated user")
+	}
+	if cfg.CanImpersonate == nil || !cfg.CanImpersonate(actor) {
+		a.logr(r.Context(), "User %q is not allowed to impersonate %q", actor.Email, targetSub)
+		a.audit(r, AuditEvent{Type: AuditImpersonate, Subject: actor.Subject, Target: targetSub, Outcome: AuditFailure, Reason: "not allowed"})
+		return fmt.Errorf("user %q is not allowed to impersonate", actor.Email)
+	}
+
+	cookie := &http.Cookie{
+		Name:     impersonateCookieName,
+		Value:    targetSub,
+		Path:     cfg.Path,
+		Secure:   cfg.cookieSecure(),
+		HttpOnly: true,
+	}
+	if targetSub == "" {
+		cookie.Expires = a.now()
+		a.logr(r.Context(), "User %q stopped impersonating", actor.Email)
+		a.audit(r, AuditEvent{Type: AuditImpersonate, Subject: actor.Subject, Reason: "stop"})
+	} else {
+		a.logr(r.Context(), "User %q started impersonating %q", actor.Email, targetSub)
+		a.audit(r, AuditEvent{Type: AuditImpersonate, Subject: actor.Subject, Target: targetSub, Reason: "start"})
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// Actor returns the credentials of the real logged in user when another user is impersonated
+// using `Auth.Impersonate`. It returns nil when there is no impersonation.
+func Actor(ctx context.Context) *Creds {
+	v := ctx.Value(actorKey)
+	if v == nil {
+		return nil
+	}
+	return v.(*Creds)
+}
+
+// impersonationTarget returns the credentials of the user that is impersonated by the
+// authenticated user, or nil if there is no impersonation. The impersonation is checked against
+// CanImpersonate on every request.
+func (a *Auth) impersonationTarget(w http.ResponseWriter, r *http.Request, creds *Creds) *Creds {
+	cookie, err := r.Cookie(impersonateCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	cfg := a.config()
+	if cfg.CanImpersonate == nil || !cfg.CanImpersonate(creds) {
+		a.logr(r.Context(), "User %q is not allowed to impersonate %q, reset impersonation", creds.Email, cookie.Value)
+		a.audit(r, AuditEvent{Type: AuditImpersonate, Subject: creds.Subject, Target: cookie.Value, Outcome: AuditFailure, Reason: "reset"})
+		http.SetCookie(w, &http.Cookie{
+			Name:     impersonateCookieName,
+			Value:    "",
+			Expires:  a.now(),
+			Path:     cfg.Path,
+			Secure:   cfg.cookieSecure(),
+			HttpOnly: true,
+		})
+		return nil
+	}
+	a.logr(r.Context(), "User %q impersonates %q: %s %s", creds.Email, cookie.Value, r.Method, r.URL.Path)
+	a.audit(r, AuditEvent{Type: AuditImpersonate, Subject: creds.Subject, Target: cookie.Value, Reason: "request"})
+	target := &Creds{Subject: cookie.Value, Key: cookie.Value}
+	target.Name = cfg.DisplayNameFunc(target)
+	return target
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImpersonate(t *testing.T) {
+	t.Parallel()
+
+	admin := &Creds{Subject: "1", Email: "admin@example.com"}
+	user := &Creds{Subject: "2", Email: "user@example.com"}
+
+	var audit bytes.Buffer
+	a := &Auth{cfg: &Config{
+		CanImpersonate:  func(c *Creds) bool { return c.Email == admin.Email },
+		DisplayNameFunc: DefaultDisplayName,
+		Audit:           NewJSONAuditSink(&audit),
+		Log:             t.Logf,
+	}}
+	lastEvent := func() AuditEvent {
+		var event AuditEvent
+		dec := json.NewDecoder(&audit)
+		for dec.More() {
+			require.NoError(t, dec.Decode(&event))
+		}
+		return event
+	}
+
+	withUser := func(c *Creds) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		return r.WithContext(context.WithValue(r.Context(), credsKey, c))
+	}
+
+	// Non admin can't impersonate.
+	rec := httptest.NewRecorder()
+	err := a.Impersonate(rec, withUser(user), "3")
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(rec.Result().Cookies()))
+
+	// Admin can impersonate.
+	rec = httptest.NewRecorder()
+	err = a.Impersonate(rec, withUser(admin), "3")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rec.Result().Cookies()))
+	cookie := rec.Result().Cookies()[0]
+	assert.Equal(t, "3", cookie.Value)
+
+	// Following requests of the admin are impersonated.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	target := a.impersonationTarget(rec, req, admin)
+	assert.Equal(t, &Creds{Subject: "3", Key: "3", Name: defaultDisplayName}, target)
+	event := lastEvent()
+	assert.Equal(t, AuditImpersonate, event.Type)
+	assert.Equal(t, "1", event.Subject)
+	assert.Equal(t, "3", event.Target)
+	assert.Equal(t, AuditSuccess, event.Outcome)
+	assert.Equal(t, "request", event.Reason)
+
+	// The impersonation cookie is ignored and reset for other users.
+	rec = httptest.NewRecorder()
+	target = a.impersonationTarget(rec, req, user)
+	assert.Nil(t, target)
+	require.Equal(t, 1, len(rec.Result().Cookies()))
+	assert.Equal(t, "", rec.Result().Cookies()[0].Value)
+	event = lastEvent()
+	assert.Equal(t, AuditImpersonate, event.Type)
+	assert.Equal(t, "2", event.Subject)
+	assert.Equal(t, "3", event.Target)
+	assert.Equal(t, AuditFailure, event.Outcome)
+	assert.Equal(t, "reset", event.Reason)
+
+	// Actor returns the real user.
+	ctx := context.WithValue(context.Background(), actorKey, admin)
+	assert.Equal(t, admin, Actor(ctx))
+	assert.Nil(t, Actor(context.Background()))
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/api/idtoken"
+)
+
+// googleIssuers are the issuers of Google ID tokens, that are validated with Google's
+// certificates.
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// validate validates an ID token and returns its payload. Without AllowedIssuers, the token is
+// validated as a Google ID token. Otherwise, the token issuer must be one of AllowedIssuers, and
+// the token is validated with the keys of that issuer.
+func (a *Auth) validate(ctx context.Context, idToken string) (*idtoken.Payload, error) {
+	cfg := a.config()
+	if len(cfg.AllowedIssuers) == 0 {
+		return a.validateGoogle(ctx, idToken)
+	}
+
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	err := decodeTokenSegment(idToken, 1, &claims)
+	if err != nil {
+		return nil, err
+	}
+	if !contains(cfg.AllowedIssuers, claims.Issuer) {
+		return nil, fmt.Errorf("issuer %q is not allowed", claims.Issuer)
+	}
+	if contains(googleIssuers, claims.Issuer) {
+		return a.validateGoogle(ctx, idToken)
+	}
+	return a.validateIssuer(ctx, claims.Issuer, idToken)
+}
+
+// validateGoogle validates a Google ID token. The Google validator checks the expiry against the
+// real time, therefore the expiry is checked again against the configured clock.
+func (a *Auth) validateGoogle(ctx context.Context, idToken string) (*idtoken.Payload, error) {
+	payload, err := a.validator.Validate(ctx, idToken, a.config().ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if a.now().Unix() > payload.Expires {
+		return nil, fmt.Errorf("token is expired")
+	}
+	return payload, nil
+}
+
+// validateIssuer validates an ID token with the keys of the given issuer.
+func (a *Auth) validateIssuer(ctx context.Context, issuer, idToken string) (*idtoken.Payload, error) {
+	claims := jwt.MapClaims{}
+	// Time claims are verified below against the configured clock.
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing algorithm %q", t.Header["alg"])
+		}
+		keyID, _ := t.Header["kid"].(string)
+		return a.issuerKey(ctx, issuer, keyID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := a.now().Unix()
+	switch {
+	case !claims.VerifyExpiresAt(now, false):
+		return nil, fmt.Errorf("token is expired")
+	case !claims.VerifyIssuedAt(now, false):
+		return nil, fmt.Errorf("token used before issued")
+	case !claims.VerifyNotBefore(now, false):
+		return nil, fmt.Errorf("token is not valid yet")
+	}
+	err = verifyAudience(claims, a.config().ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &idtoken.Payload{Claims: claims}
+	payload.Issuer, _ = claims["iss"].(string)
+	payload.Subject, _ = claims["sub"].(string)
+	payload.Audience = a.config().ClientID
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("missing \"exp\" claim")
+	}
+	payload.Expires = int64(exp)
+	if iat, ok := claims["iat"].(float64); ok {
+		payload.IssuedAt = int64(iat)
+	}
+	return payload, nil
+}
+
+// verifyAudience verifies that the client ID is an audience of an ID token. The "aud" claim may
+// be a string or an array of strings. When the token has several audiences, or an "azp" claim,
+// the "azp" claim must be the client ID.
+func verifyAudience(claims map[string]interface{}, clientID string) error {
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, v := range aud {
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("invalid \"aud\" claim %v", aud)
+			}
+			audiences = append(audiences, s)
+		}
+	}
+	if !contains(audiences, clientID) {
+		return fmt.Errorf("audience does not match client ID")
+	}
+	azp, hasAZP := claims["azp"]
+	if (len(audiences) > 1 || hasAZP) && azp != clientID {
+		return fmt.Errorf("authorized party %v does not match client ID", azp)
+	}
+	return nil
+}
+
+// keySet is the set of signing keys of an issuer, by key ID.
+type keySet map[string]*rsa.PublicKey
+
+// keySetEntry is a cached key set.
+type keySetEntry struct {
+	keys    keySet
+	fetched time.Time
+	expires time.Time
+}
+
+// issuerKey returns the issuer signing key with the given key ID. The issuer keys are fetched
+// on first use, cached according to the Cache-Control header up to JWKSCacheTTL, and fetched
+// again when the key ID is unknown, to support key rotation. The key is looked up before the
+// token signature is verified, therefore unknown key IDs fetch the keys at most once per
+// minProviderCacheTTL, such that forged tokens can't make every request fetch them.
+func (a *Auth) issuerKey(ctx context.Context, issuer, keyID string) (*rsa.PublicKey, error) {
+	now := a.now()
+	a.keySetsMu.Lock()
+	entry, ok := a.keySets[issuer]
+	a.keySetsMu.Unlock()
+	if ok && now.Before(entry.expires) {
+		if key := entry.keys[keyID]; key != nil {
+			return key, nil
+		}
+		if now.Before(entry.fetched.Add(minProviderCacheTTL)) {
+			return nil, fmt.Errorf("unknown key ID %q for issuer %q", keyID, issuer)
+		}
+	}
+
+	// Concurrent requests with an unknown key share a single fetch.
+	v, err := a.discovery.do(ctx, "keys "+issuer, func() (interface{}, error) {
+		ctx, cancel := a.flightContext()
+		defer cancel()
+		keys, ttl, err := a.fetchKeySet(ctx, issuer)
+		if err != nil {
+			return nil, err
+		}
+		a.keySetsMu.Lock()
+		if a.keySets == nil {
+			a.keySets = make(map[string]*keySetEntry)
+		}
+		now := a.now()
+		a.keySets[issuer] = &keySetEntry{keys: keys, fetched: now, expires: now.Add(ttl)}
+		a.keySetsMu.Unlock()
+		return keys, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching keys of issuer %q: %v", issuer, err)
+	}
+
+	key := v.(keySet)[keyID]
+	if key == nil {
+		return nil, fmt.Errorf("unknown key ID %q for issuer %q", keyID, issuer)
+	}
+	return key, nil
+}
+
+// fetchKeySet fetches the signing keys of the issuer using OIDC discovery, and returns the
+// duration that they should be cached.
+func (a *Auth) fetchKeySet(ctx context.Context, issuer string) (keySet, time.Duration, error) {
+	discovery, err := a.discover(ctx, issuer)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	header, err := a.getJSON(ctx, discovery.JWKSURI, &jwks)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keys := make(keySet)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid key %q modulus: %v", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid key %q exponent: %v", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, providerCacheTTL(header.Get("Cache-Control"), a.config().jwksCacheTTL()), nil
+}
+
+// getJSON fetches the given URL, decodes the JSON response into v, and returns the response
+// header.
+func (a *Auth) getJSON(ctx context.Context, url string, v interface{}) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.config().Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp.Header, json.NewDecoder(resp.Body).Decode(v)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
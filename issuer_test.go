@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestAllowedIssuers(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.New(rand.NewSource(1)), 1024)
+	require.NoError(t, err)
+
+	issuer := newTestIssuer(t, privateKey, "keyid")
+	defer issuer.Close()
+
+	a, err := New(context.Background(), Config{
+		Config:         oauth2.Config{ClientID: "client1"},
+		AllowedIssuers: []string{issuer.URL},
+		Log:            t.Logf,
+	})
+	require.NoError(t, err)
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": issuer.URL,
+			"aud": "client1",
+			"sub": "123",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		keyID   string
+		modify  func(jwt.MapClaims)
+		wantErr bool
+	}{
+		{name: "valid", key: privateKey, keyID: "keyid", modify: func(jwt.MapClaims) {}},
+		{name: "unlisted issuer", key: privateKey, keyID: "keyid", modify: func(c jwt.MapClaims) { c["iss"] = "https://other.com" }, wantErr: true},
+		{name: "other key", key: otherKey, keyID: "keyid", modify: func(jwt.MapClaims) {}, wantErr: true},
+		{name: "unknown key ID", key: privateKey, keyID: "other", modify: func(jwt.MapClaims) {}, wantErr: true},
+		{name: "other audience", key: privateKey, keyID: "keyid", modify: func(c jwt.MapClaims) { c["aud"] = "client2" }, wantErr: true},
+		{name: "audience array", key: privateKey, keyID: "keyid", modify: func(c jwt.MapClaims) { c["aud"] = []string{"client1"} }},
+		{name: "audience array without client", key: privateKey, keyID: "keyid", modify: func(c jwt.MapClaims) { c["aud"] = []string{"client2"} }, wantErr: true},
+		{name: "multiple audiences", key: privateKey, keyID: "keyid", modify: func(c jwt.MapClaims) { c["aud"], c["azp"] = []string{"client1", "client2"}, "client1" }},
+		{name: "multiple audiences without azp", key: privateKey, keyID: "keyid", modify: func(c jwt.MapClaims) { c["aud"] = []string{"client1", "client2"} }, wantErr: true},
+		{name: "other azp", key: privateKey, keyID: "keyid", modify: func(c jwt.MapClaims) { c["azp"] = "client2" }, wantErr: true},
+		{name: "expired", key: privateKey, keyID: "keyid", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: true},
+		{name: "missing expiry", key: privateKey, keyID: "keyid", modify: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(claims)
+			idToken := genSignedClaims(t, tt.keyID, tt.key, claims)
+
+			payload, err := a.validate(context.Background(), idToken)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, issuer.URL, payload.Issuer)
+			assert.Equal(t, "123", payload.Subject)
+		})
+	}
+}
+
+// newTestIssuer returns an OIDC issuer server that serves discovery and the keys of the given
+// private key.
+func newTestIssuer(t *testing.T, privateKey *rsa.PrivateKey, keyID string) *httptest.Server {
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp interface{}
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			resp = map[string]string{"issuer": s.URL, "jwks_uri": s.URL + "/jwks"}
+		case "/jwks":
+			c := newCert(privateKey, keyID)
+			resp = map[string]interface{}{"keys": []map[string]string{{"kty": "RSA", "kid": c.KID, "n": c.N, "e": c.E}}}
+		default:
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	return s
+}
+
+func TestUnknownKeyIDRefetch(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+	issuer := newTestIssuer(t, privateKey, "keyid")
+	defer issuer.Close()
+
+	var jwks int32
+	issuerHandler := issuer.Config.Handler
+	issuer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jwks" {
+			atomic.AddInt32(&jwks, 1)
+		}
+		issuerHandler.ServeHTTP(w, r)
+	})
+
+	now := time.Now()
+	a, err := New(context.Background(), Config{
+		Config:         oauth2.Config{ClientID: "client1"},
+		AllowedIssuers: []string{issuer.URL},
+		Clock:          func() time.Time { return now },
+		Log:            t.Logf,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = a.issuerKey(ctx, issuer.URL, "keyid")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&jwks))
+
+	// Unknown key IDs don't fetch the fresh keys again.
+	for i := 0; i < 10; i++ {
+		_, err = a.issuerKey(ctx, issuer.URL, fmt.Sprintf("forged%d", i))
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&jwks))
+
+	// After minProviderCacheTTL, an unknown key ID fetches the keys once, to support key rotation.
+	now = now.Add(minProviderCacheTTL + time.Second)
+	for i := 0; i < 10; i++ {
+		_, err = a.issuerKey(ctx, issuer.URL, fmt.Sprintf("forged%d", i))
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&jwks))
+}
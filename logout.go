@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+const (
+	// backchannelLogoutEvent is the event that identifies a logout token, as defined in
+	// https://openid.net/specs/openid-connect-backchannel-1_0.html#LogoutToken.
+	backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+	// revocationTTL is the duration that revoked sessions are remembered.
+	revocationTTL = 24 * time.Hour
+)
+
+// BackchannelLogoutHandler handles OIDC back-channel logout requests, that are sent by the
+// provider when a user session at the provider ends. It should be mounted on the path that is
+// registered as the back-channel logout URI at the provider.
+//
+// The logout token is validated and the session with the "sid" of the logout token is rejected
+// by `Authenticate`. When the logout token has no "sid", all sessions of the user that logged in
+// before the logout are rejected. Logouts are kept in memory for 24 hours, therefore when running
+// multiple instances, the provider should be able to reach all of them. Older logouts rely on the
+// provider to reject the refresh of the logged out sessions.
+func (a *Auth) BackchannelLogoutHandler() http.Handler {
+	return a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		logoutToken := r.PostFormValue("logout_token")
+		payload, err := a.validate(r.Context(), logoutToken)
+		if err != nil {
+			a.logr(r.Context(), "Invalid logout token: %s", err)
+			http.Error(w, "Invalid logout token", http.StatusBadRequest)
+			return
+		}
+
+		events, _ := payload.Claims["events"].(map[string]interface{})
+		if _, ok := events[backchannelLogoutEvent]; !ok {
+			a.logr(r.Context(), "Logout token is missing the back-channel logout event")
+			http.Error(w, "Invalid logout token", http.StatusBadRequest)
+			return
+		}
+		if _, ok := payload.Claims["nonce"]; ok {
+			a.logr(r.Context(), "Logout token must not contain a nonce")
+			http.Error(w, "Invalid logout token", http.StatusBadRequest)
+			return
+		}
+		sessionID, _ := payload.Claims["sid"].(string)
+		if payload.Subject == "" && sessionID == "" {
+			a.logr(r.Context(), "Logout token is missing the subject and the session ID")
+			http.Error(w, "Invalid logout token", http.StatusBadRequest)
+			return
+		}
+
+		if sessionID != "" {
+			a.revokeSession(sessionID)
+			a.audit(r, AuditEvent{Type: AuditLogout, Subject: payload.Subject, Reason: "back-channel logout of session " + sessionID})
+			a.logr(r.Context(), "Session %q of subject %q was logged out by the provider", sessionID, payload.Subject)
+			return
+		}
+		a.revoke(payload.Subject)
+		a.audit(r, AuditEvent{Type: AuditLogout, Subject: payload.Subject, Reason: "back-channel logout"})
+		a.logr(r.Context(), "Subject %q was logged out by the provider", payload.Subject)
+	}))
+}
+
+// revoke rejects all the sessions of the subject that logged in until now.
+func (a *Auth) revoke(subject string) {
+	now := a.now()
+	a.revocationsMu.Lock()
+	defer a.revocationsMu.Unlock()
+	if a.revocations == nil {
+		a.revocations = make(map[string]time.Time)
+	}
+	a.pruneRevocations(now)
+	a.revocations[subject] = now
+}
+
+// revokeSession rejects the session with the given provider session ID.
+func (a *Auth) revokeSession(sessionID string) {
+	now := a.now()
+	a.revocationsMu.Lock()
+	defer a.revocationsMu.Unlock()
+	if a.sessionRevocations == nil {
+		a.sessionRevocations = make(map[string]time.Time)
+	}
+	a.pruneRevocations(now)
+	a.sessionRevocations[sessionID] = now
+}
+
+// pruneRevocations drops the revocations that are older than revocationTTL, such that the
+// revocations don't pile up. It must be called with revocationsMu held.
+func (a *Auth) pruneRevocations(now time.Time) {
+	for subject, revokedAt := range a.revocations {
+		if now.Sub(revokedAt) > revocationTTL {
+			delete(a.revocations, subject)
+		}
+	}
+	for sessionID, revokedAt := range a.sessionRevocations {
+		if now.Sub(revokedAt) > revocationTTL {
+			delete(a.sessionRevocations, sessionID)
+		}
+	}
+}
+
+// revoked returns whether the session with the provider session ID, or a session of the subject
+// that logged in at the given unix time, was logged out by the provider.
+func (a *Auth) revoked(subject, sessionID string, loginAt int64) bool {
+	a.revocationsMu.Lock()
+	defer a.revocationsMu.Unlock()
+	if _, ok := a.sessionRevocations[sessionID]; sessionID != "" && ok {
+		return true
+	}
+	revokedAt, ok := a.revocations[subject]
+	return ok && loginAt <= revokedAt.Unix()
+}
+
+// tokenSessionID returns the "sid" claim of an ID token, without verifying it. The session ID is
+// verified with the ID token in `Authenticate`.
+func tokenSessionID(idToken string) string {
+	var claims struct {
+		SessionID string `json:"sid"`
+	}
+	_ = decodeTokenSegment(idToken, 1, &claims)
+	return claims.SessionID
+}
+
+// logoutCSRFField is the form field of the logout CSRF token.
+const logoutCSRFField = "csrf_token"
+
+// logoutFormTemplate is the logout confirmation form.
+var logoutFormTemplate = template.Must(template.New("logout").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Log out</title></head>
+<body>
+<form method="post">
+<input type="hidden" name="` + logoutCSRFField + `" value="{{.}}">
+<p>Log out?</p>
+<button type="submit">Log out</button>
+</form>
+</body>
+</html>
+`))
+
+// LogoutCSRFToken returns the CSRF token that should be sent in the "csrf_token" form value of a
+// logout POST request. The token is bound to the session of the request, and changes when the
+// session is renewed. It returns an empty string if the request has no session.
+func (a *Auth) LogoutCSRFToken(r *http.Request) string {
+	cookie, err := a.sessionCookie(r)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	// The session cookie is not readable by JavaScript, therefore other sites can't compute it.
+	sum := sha256.Sum256([]byte("logout:" + cookie.Value))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// validLogoutCSRFToken returns whether a logout POST request has a valid CSRF token. Requests
+// without a session have nothing to log out of and are allowed.
+func (a *Auth) validLogoutCSRFToken(r *http.Request) bool {
+	want := a.LogoutCSRFToken(r)
+	if want == "" {
+		return true
+	}
+	got := r.PostFormValue(logoutCSRFField)
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// logoutForm writes the logout confirmation form.
+func (a *Auth) logoutForm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	err := logoutFormTemplate.Execute(w, a.LogoutCSRFToken(r))
+	if err != nil {
+		a.logr(r.Context(), "Failed writing logout form: %v", err)
+	}
+}
+
+// auditLogout records the logout of the session of the request.
+func (a *Auth) auditLogout(r *http.Request) {
+	t, err := a.getCookie(r)
+	if err != nil || t == nil {
+		return
+	}
+	a.audit(r, AuditEvent{Type: AuditLogout, Subject: unverifiedSubject(t.IDToken)})
+}
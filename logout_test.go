@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestBackchannelLogout(t *testing.T) {
+	t.Parallel()
+
+	privateKey, privateKeyCert := newTestKey(t)
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{ClientID: "client1"},
+		Log:    t.Logf,
+		Client: fakeClient(t, certResp{Keys: []cert{privateKeyCert}}),
+	})
+	require.NoError(t, err)
+
+	loginAt := time.Now().Add(-time.Minute).Unix()
+	events := map[string]interface{}{backchannelLogoutEvent: map[string]interface{}{}}
+
+	tests := []struct {
+		name       string
+		method     string
+		claims     jwt.MapClaims
+		wantStatus int
+		wantRevoke bool
+		// wantRevokeSession is whether only the session with the "sid" is revoked.
+		wantRevokeSession bool
+	}{
+		{
+			name:       "valid",
+			method:     http.MethodPost,
+			claims:     jwt.MapClaims{"sub": "valid", "events": events},
+			wantStatus: http.StatusOK,
+			wantRevoke: true,
+		},
+		{
+			name:              "session",
+			method:            http.MethodPost,
+			claims:            jwt.MapClaims{"sub": "session", "sid": "session-1", "events": events},
+			wantStatus:        http.StatusOK,
+			wantRevokeSession: true,
+		},
+		{
+			name:              "session without subject",
+			method:            http.MethodPost,
+			claims:            jwt.MapClaims{"sid": "session-2", "events": events},
+			wantStatus:        http.StatusOK,
+			wantRevokeSession: true,
+		},
+		{
+			name:       "missing subject and session",
+			method:     http.MethodPost,
+			claims:     jwt.MapClaims{"events": events},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "get",
+			method:     http.MethodGet,
+			claims:     jwt.MapClaims{"sub": "get", "events": events},
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "missing event",
+			method:     http.MethodPost,
+			claims:     jwt.MapClaims{"sub": "missing event"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "nonce",
+			method:     http.MethodPost,
+			claims:     jwt.MapClaims{"sub": "nonce", "events": events, "nonce": "nonce"},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.claims["aud"] = "client1"
+			tt.claims["exp"] = time.Now().Add(time.Minute).Unix()
+			logoutToken := genSignedClaims(t, privateKeyCert.KID, privateKey, tt.claims)
+
+			body := url.Values{"logout_token": {logoutToken}}.Encode()
+			req := httptest.NewRequest(tt.method, "/backchannel-logout", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			a.BackchannelLogoutHandler().ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Result().StatusCode)
+			sub, _ := tt.claims["sub"].(string)
+			sid, _ := tt.claims["sid"].(string)
+			assert.Equal(t, tt.wantRevoke, a.revoked(sub, "", loginAt))
+			assert.Equal(t, tt.wantRevoke || tt.wantRevokeSession, a.revoked(sub, sid, loginAt))
+			if sid != "" {
+				// Other sessions of the subject are not revoked.
+				assert.False(t, a.revoked(sub, "other", loginAt))
+			}
+		})
+	}
+
+	// A new login after the logout is not revoked.
+	assert.False(t, a.revoked("valid", "", time.Now().Add(time.Minute).Unix()))
+
+	// Authenticate rejects sessions by the "sid" of the ID token.
+	for _, sid := range []string{"session-1", "session-3"} {
+		idToken := genSignedClaims(t, privateKeyCert.KID, privateKey, jwt.MapClaims{
+			"sub": "session",
+			"sid": sid,
+			"aud": "client1",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+		session := newTokenCookie(t, &token{
+			Token:   &oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)},
+			IDToken: idToken,
+			LoginAt: loginAt,
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(session)
+		rec := httptest.NewRecorder()
+		a.Authenticate(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(rec, req)
+		if sid == "session-1" {
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		} else {
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}
+	}
+}
+
+func TestTokenSessionID(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+	assert.Equal(t, "sid1", tokenSessionID(genSignedClaims(t, "keyid", privateKey, jwt.MapClaims{"sid": "sid1"})))
+	assert.Equal(t, "", tokenSessionID(genSignedClaims(t, "keyid", privateKey, jwt.MapClaims{"sub": "sub1"})))
+	assert.Equal(t, "", tokenSessionID("invalid"))
+}
+
+func genSignedClaims(t *testing.T, privateKeyID string, privateKey *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = privateKeyID
+	signedToken, err := token.SignedString(privateKey)
+	require.NoError(t, err)
+	return signedToken
+}
+
+func TestLogoutHandler(t *testing.T) {
+	t.Parallel()
+
+	session := &http.Cookie{Name: cookieName, Value: "session"}
+	newRequest := func(method string, form url.Values) *http.Request {
+		req := httptest.NewRequest(method, "/logout", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(session)
+		return req
+	}
+	loggedOut := func(rec *httptest.ResponseRecorder) bool {
+		cookies := rec.Result().Cookies()
+		return len(cookies) == 1 && cookies[0].Name == cookieName && cookies[0].Value == ""
+	}
+
+	a := &Auth{cfg: &Config{}}
+	token := a.LogoutCSRFToken(newRequest(http.MethodGet, nil))
+	require.NotEmpty(t, token)
+
+	// GET logs out by default.
+	rec := httptest.NewRecorder()
+	a.LogoutHandler("/").ServeHTTP(rec, newRequest(http.MethodGet, nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.True(t, loggedOut(rec))
+
+	// POST requires the CSRF token.
+	rec = httptest.NewRecorder()
+	a.LogoutHandler("/").ServeHTTP(rec, newRequest(http.MethodPost, url.Values{"csrf_token": {"invalid"}}))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.False(t, loggedOut(rec))
+
+	rec = httptest.NewRecorder()
+	a.LogoutHandler("/").ServeHTTP(rec, newRequest(http.MethodPost, url.Values{"csrf_token": {token}}))
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.True(t, loggedOut(rec))
+
+	// When POST is required, GET renders a confirmation form with the token.
+	a = &Auth{cfg: &Config{LogoutRequirePOST: true}}
+	rec = httptest.NewRecorder()
+	a.LogoutHandler("/").ServeHTTP(rec, newRequest(http.MethodGet, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, loggedOut(rec))
+	assert.Contains(t, rec.Body.String(), `method="post"`)
+	assert.Contains(t, rec.Body.String(), `value="`+token+`"`)
+}
+
+func TestRevocationsPruned(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	a := &Auth{cfg: &Config{Clock: func() time.Time { return now }}}
+	loginAt := now.Add(-time.Minute).Unix()
+
+	a.revoke("user1")
+	a.revokeSession("session1")
+	assert.True(t, a.revoked("user1", "", loginAt))
+	assert.True(t, a.revoked("user2", "session1", loginAt))
+
+	// Revocations older than revocationTTL are dropped when another session is revoked.
+	now = now.Add(revocationTTL + time.Minute)
+	a.revoke("user3")
+	assert.False(t, a.revoked("user1", "", loginAt))
+	assert.False(t, a.revoked("user2", "session1", loginAt))
+	assert.Len(t, a.revocations, 1)
+	assert.Len(t, a.sessionRevocations, 0)
+}
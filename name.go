@@ -0,0 +1,22 @@
+package auth
+
+import "strings"
+
+// defaultDisplayName is the display name of users that have no name, given name or email.
+const defaultDisplayName = "User"
+
+// DefaultDisplayName is the default `Config.DisplayNameFunc`. It returns the name of the user.
+// When the name is empty, it falls back to the given and family names, the local part of the
+// email, and finally to "User".
+func DefaultDisplayName(c *Creds) string {
+	if name := strings.TrimSpace(c.Name); name != "" {
+		return name
+	}
+	if name := strings.TrimSpace(c.GivenName + " " + c.FamilyName); name != "" {
+		return name
+	}
+	if i := strings.Index(c.Email, "@"); i > 0 {
+		return c.Email[:i]
+	}
+	return defaultDisplayName
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestDefaultDisplayName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		creds Creds
+		want  string
+	}{
+		{name: "name", creds: Creds{Name: "John Doe", GivenName: "Johnny", Email: "john@example.com"}, want: "John Doe"},
+		{name: "given and family", creds: Creds{GivenName: "John", FamilyName: "Doe", Email: "john@example.com"}, want: "John Doe"},
+		{name: "given", creds: Creds{GivenName: "John", Email: "john@example.com"}, want: "John"},
+		{name: "family", creds: Creds{FamilyName: "Doe"}, want: "Doe"},
+		{name: "blank name", creds: Creds{Name: " ", Email: "john@example.com"}, want: "john"},
+		{name: "email", creds: Creds{Email: "john@example.com"}, want: "john"},
+		{name: "invalid email", creds: Creds{Email: "@example.com"}, want: "User"},
+		{name: "empty", creds: Creds{}, want: "User"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, DefaultDisplayName(&tt.creds))
+		})
+	}
+}
+
+func TestDisplayNameFunc(t *testing.T) {
+	t.Parallel()
+
+	privateKey, privateKeyCert := newTestKey(t)
+	session := newSessionCookie(t, genSignedToken(t, privateKeyCert.KID, privateKey, "client1", "john@example.com", ""))
+
+	tests := []struct {
+		name            string
+		displayNameFunc func(*Creds) string
+		want            string
+	}{
+		{name: "default", want: "john"},
+		{name: "custom", displayNameFunc: func(c *Creds) string { return "Dear " + c.Email }, want: "Dear john@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(context.Background(), Config{
+				Config:          oauth2.Config{ClientID: "client1"},
+				DisplayNameFunc: tt.displayNameFunc,
+				Log:             t.Logf,
+				Client:          fakeClient(t, certResp{Keys: []cert{privateKeyCert}}),
+			})
+			require.NoError(t, err)
+
+			var got string
+			h := a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = User(r.Context()).Name
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(session)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// oneTapCSRFName is the name of the double submit CSRF cookie and form field of Google One Tap.
+const oneTapCSRFName = "g_csrf_token"
+
+// OneTapHandler handles the ID token credential that Google One Tap and the Sign In With Google
+// button post to their "login_uri", and logs the user in. It should be mounted on the login_uri
+// path. The post is protected by the double submit "g_csrf_token" cookie and form field that
+// Google sets, and requests where they are missing or don't match are rejected.
+//
+// After login, the user is redirected to the local path in the "next" query parameter, or to
+// "/". Since no OAuth2 tokens are issued, the session can't be renewed. When the ID token
+// expires, the user is redirected to login again.
+func (a *Auth) OneTapHandler() http.Handler {
+	return a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if a.deniesAll() {
+			a.logr(r.Context(), "Login refused by the deny all mode")
+			a.maintenance(w, r)
+			return
+		}
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			a.logr(r.Context(), "Failed parsing One Tap form: %v", err)
+			return
+		}
+		if !validOneTapCSRF(r) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			a.logr(r.Context(), "One Tap login with an invalid CSRF token")
+			a.audit(r, AuditEvent{Type: AuditLogin, Outcome: AuditFailure, Reason: "invalid One Tap CSRF token"})
+			return
+		}
+
+		credential := r.PostForm.Get("credential")
+		payload, err := a.validate(r.Context(), credential)
+		if err != nil {
+			http.Error(w, "Authorization failure", http.StatusUnauthorized)
+			a.logr(r.Context(), "Invalid One Tap credential: %v", err)
+			a.audit(r, AuditEvent{Type: AuditLogin, Outcome: AuditFailure, Reason: err.Error()})
+			return
+		}
+		if claim := a.config().UserKeyClaim; claim != "" {
+			_, err = userKey(payload.Claims, claim)
+			if err != nil {
+				http.Error(w, "Authorization failure", http.StatusUnauthorized)
+				a.logr(r.Context(), "Invalid One Tap credential user key: %s", err)
+				return
+			}
+		}
+
+		// The session expires with the ID token, and then the user is sent to login again.
+		err = a.setLoginCookie(w, &token{
+			Token:     &oauth2.Token{TokenType: "Bearer", Expiry: time.Unix(payload.Expires, 0)},
+			IDToken:   credential,
+			LoginAt:   a.now().Unix(),
+			SessionID: tokenSessionID(credential),
+		})
+		if err != nil {
+			a.logr(r.Context(), "Failed setting cookie: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		redirectPath := localPath(r.URL.Query().Get("next"))
+		a.logr(r.Context(), "Successful One Tap login, redirect to application path %q", redirectPath)
+		a.audit(r, AuditEvent{Type: AuditLogin, Subject: payload.Subject})
+		http.Redirect(w, r, redirectPath, http.StatusSeeOther)
+	}))
+}
+
+// validOneTapCSRF returns whether the One Tap CSRF token in the form matches the cookie.
+func validOneTapCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(oneTapCSRFName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	body := r.PostForm.Get(oneTapCSRFName)
+	return body != "" && subtle.ConstantTimeCompare([]byte(body), []byte(cookie.Value)) == 1
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestOneTapHandler(t *testing.T) {
+	t.Parallel()
+
+	privateKey, privateKeyCert := newTestKey(t)
+	credential := genSignedToken(t, privateKeyCert.KID, privateKey, "client1", "email@example.com", "John")
+	otherCredential := genSignedToken(t, privateKeyCert.KID, privateKey, "client2", "email@example.com", "John")
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{ClientID: "client1"},
+		Log:    t.Logf,
+		Client: fakeClient(t, certResp{Keys: []cert{privateKeyCert}}),
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		method       string
+		credential   string
+		bodyCSRF     string
+		cookieCSRF   string
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "valid", method: http.MethodPost, credential: credential, bodyCSRF: "csrf", cookieCSRF: "csrf", wantStatus: http.StatusSeeOther, wantLocation: "/app"},
+		{name: "missing cookie", method: http.MethodPost, credential: credential, bodyCSRF: "csrf", wantStatus: http.StatusForbidden},
+		{name: "missing body token", method: http.MethodPost, credential: credential, cookieCSRF: "csrf", wantStatus: http.StatusForbidden},
+		{name: "mismatch", method: http.MethodPost, credential: credential, bodyCSRF: "csrf", cookieCSRF: "other", wantStatus: http.StatusForbidden},
+		{name: "other audience", method: http.MethodPost, credential: otherCredential, bodyCSRF: "csrf", cookieCSRF: "csrf", wantStatus: http.StatusUnauthorized},
+		{name: "get", method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"credential": {tt.credential}, oneTapCSRFName: {tt.bodyCSRF}}
+			req := httptest.NewRequest(tt.method, "/onetap?next=/app", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookieCSRF != "" {
+				req.AddCookie(&http.Cookie{Name: oneTapCSRFName, Value: tt.cookieCSRF})
+			}
+			rec := httptest.NewRecorder()
+			a.OneTapHandler().ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantLocation, rec.Header().Get("Location"))
+			var session *http.Cookie
+			for _, c := range rec.Result().Cookies() {
+				if c.Name == cookieName {
+					session = c
+				}
+			}
+			assert.Equal(t, tt.wantStatus == http.StatusSeeOther, session != nil)
+		})
+	}
+}
+
+func TestOneTapSessionExpiry(t *testing.T) {
+	t.Parallel()
+
+	privateKey, privateKeyCert := newTestKey(t)
+	credential := genSignedToken(t, privateKeyCert.KID, privateKey, "client1", "email@example.com", "John")
+	clock := &fakeClock{now: time.Now()}
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{ClientID: "client1", RedirectURL: "https://example.com/auth"},
+		Clock:  clock.Now,
+		Log:    t.Logf,
+		Client: fakeClient(t, certResp{Keys: []cert{privateKeyCert}}),
+	})
+	require.NoError(t, err)
+
+	form := url.Values{"credential": {credential}, oneTapCSRFName: {"csrf"}}
+	req := httptest.NewRequest(http.MethodPost, "/onetap", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: oneTapCSRFName, Value: "csrf"})
+	rec := httptest.NewRecorder()
+	a.OneTapHandler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == cookieName {
+			session = c
+		}
+	}
+	require.NotNil(t, session)
+
+	h := a.Authenticate(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/app", nil)
+		req.AddCookie(session)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec = serve()
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// After the ID token expired, the user is sent to login again.
+	clock.Add(2 * time.Hour)
+	rec = serve()
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/app", location.Query().Get("state"))
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// pushAuthRequest pushes the parameters of the given authorization URL to the pushed
+// authorization request endpoint (RFC 9126), and returns the authorization URL that refers to the
+// pushed request by the returned request_uri.
+func (a *Auth) pushAuthRequest(ctx context.Context, authURL string) (string, error) {
+	cfg := a.config()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := a.parEndpoint(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed getting PAR endpoint: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(u.Query().Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cfg.ClientPrivateKey == nil {
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return "", fmt.Errorf("POST %s: %s: %s %s", endpoint, resp.Status, e.Error, e.Description)
+	}
+
+	var par struct {
+		RequestURI string `json:"request_uri"`
+		ExpiresIn  int    `json:"expires_in"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&par)
+	if err != nil {
+		return "", fmt.Errorf("failed decoding PAR response: %v", err)
+	}
+	if par.RequestURI == "" {
+		return "", fmt.Errorf("PAR response is missing request_uri")
+	}
+
+	u.RawQuery = url.Values{"client_id": {cfg.ClientID}, "request_uri": {par.RequestURI}}.Encode()
+	return u.String(), nil
+}
+
+// parEndpoint returns PAREndpoint, or the endpoint from the OIDC discovery of the first of
+// AllowedIssuers.
+func (a *Auth) parEndpoint(ctx context.Context) (string, error) {
+	cfg := a.config()
+	if cfg.PAREndpoint != "" {
+		return cfg.PAREndpoint, nil
+	}
+
+	issuer := cfg.AllowedIssuers[0]
+	discovery, err := a.discover(ctx, issuer)
+	if err != nil {
+		return "", err
+	}
+	if discovery.PAREndpoint == "" {
+		return "", fmt.Errorf("issuer %q does not support pushed authorization requests", issuer)
+	}
+	return discovery.PAREndpoint, nil
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestUsePAR(t *testing.T) {
+	t.Parallel()
+
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                                s.URL,
+				"pushed_authorization_request_endpoint": s.URL + "/par",
+			})
+		case "/par":
+			assert.Equal(t, http.MethodPost, r.Method)
+			id, secret, ok := r.BasicAuth()
+			assert.True(t, ok)
+			assert.Equal(t, "client1", id)
+			assert.Equal(t, "secret1", secret)
+			assert.Equal(t, "https://example.org/auth", r.FormValue("redirect_uri"))
+			if r.FormValue("state") != "/next" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]interface{}{"request_uri": "urn:example:request", "expires_in": 60})
+		default:
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	oauth2Cfg := oauth2.Config{
+		ClientID:     "client1",
+		ClientSecret: "secret1",
+		RedirectURL:  "https://example.org/auth",
+		Endpoint:     oauth2.Endpoint{AuthURL: s.URL + "/authorize", TokenURL: s.URL + "/token"},
+	}
+
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "endpoint", cfg: Config{Config: oauth2Cfg, UsePAR: true, PAREndpoint: s.URL + "/par"}},
+		{name: "discovery", cfg: Config{Config: oauth2Cfg, UsePAR: true, AllowedIssuers: []string{s.URL}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Log = t.Logf
+			a, err := New(context.Background(), tt.cfg)
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			a.LoginHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login?next=/next", nil))
+			require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+			loc, err := url.Parse(rec.Header().Get("Location"))
+			require.NoError(t, err)
+			assert.Equal(t, s.URL+"/authorize", loc.Scheme+"://"+loc.Host+loc.Path)
+			assert.Equal(t, url.Values{"client_id": {"client1"}, "request_uri": {"urn:example:request"}}, loc.Query())
+
+			// A rejected request fails the login.
+			rec = httptest.NewRecorder()
+			a.LoginHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login?next=/other", nil))
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		})
+	}
+
+	_, err := New(context.Background(), Config{Config: oauth2Cfg, UsePAR: true})
+	assert.Error(t, err)
+}
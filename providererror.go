@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/oauth2"
+)
+
+// Errors that classify the OAuth2 errors of the provider. A `*ProviderError` wraps one of them,
+// and can be checked with `errors.Is`.
+var (
+	// ErrAccessDenied is returned when the user cancelled the login, or the provider refused it.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrInvalidGrant is returned when the authorization code is invalid, expired or was already
+	// used. The login can be retried.
+	ErrInvalidGrant = errors.New("invalid grant")
+	// ErrProviderUnavailable is returned when the provider is temporarily unavailable.
+	ErrProviderUnavailable = errors.New("provider unavailable")
+	// ErrProvider is returned for other provider errors, that are usually caused by a
+	// misconfiguration.
+	ErrProvider = errors.New("provider error")
+)
+
+// providerErrorKinds maps the OAuth2 error codes to the error that classifies them.
+var providerErrorKinds = map[string]error{
+	"access_denied":              ErrAccessDenied,
+	"consent_required":           ErrAccessDenied,
+	"interaction_required":       ErrAccessDenied,
+	"login_required":             ErrAccessDenied,
+	"account_selection_required": ErrAccessDenied,
+	"invalid_grant":              ErrInvalidGrant,
+	"server_error":               ErrProviderUnavailable,
+	"temporarily_unavailable":    ErrProviderUnavailable,
+}
+
+// maxErrorDescription is the maximum length of a provider error description that is returned to
+// the client.
+const maxErrorDescription = 200
+
+// ProviderError is an OAuth2 error that was returned by the provider during login, either in the
+// authorization response or in the token response.
+type ProviderError struct {
+	// Code is the OAuth2 error code, such as "access_denied".
+	Code string
+	// Description is the sanitized human readable description of the error.
+	Description string
+}
+
+func (e *ProviderError) Error() string {
+	if e.Description == "" {
+		return "provider error " + e.Code
+	}
+	return "provider error " + e.Code + ": " + e.Description
+}
+
+// Unwrap returns the error that classifies the provider error, one of ErrAccessDenied,
+// ErrInvalidGrant, ErrProviderUnavailable or ErrProvider.
+func (e *ProviderError) Unwrap() error {
+	if kind, ok := providerErrorKinds[e.Code]; ok {
+		return kind
+	}
+	return ErrProvider
+}
+
+// newProviderError returns a provider error with a sanitized code and description.
+func newProviderError(code, description string) *ProviderError {
+	return &ProviderError{Code: sanitizeErrorCode(code), Description: sanitizeErrorDescription(description)}
+}
+
+// authorizationError returns the provider error of the parameters of an authorization error
+// response, or nil if the callback is not an error response.
+func authorizationError(params url.Values) *ProviderError {
+	if params.Get("error") == "" {
+		return nil
+	}
+	return newProviderError(params.Get("error"), params.Get("error_description"))
+}
+
+// tokenError returns the provider error of a failed token request, or nil if the provider did not
+// respond with an OAuth2 error.
+func tokenError(err error) *ProviderError {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return nil
+	}
+	var body struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	_ = json.Unmarshal(retrieveErr.Body, &body)
+	if body.Error == "" {
+		return nil
+	}
+	return newProviderError(body.Error, body.Description)
+}
+
+// sanitizeErrorCode returns the error code if it is a valid OAuth2 error code, made of lowercase
+// letters, digits and underscores, and "unknown" otherwise.
+func sanitizeErrorCode(code string) string {
+	if code == "" || len(code) > 64 {
+		return "unknown"
+	}
+	for _, c := range code {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			return "unknown"
+		}
+	}
+	return code
+}
+
+// sanitizeErrorDescription removes non printable characters from the error description and
+// truncates it.
+func sanitizeErrorDescription(description string) string {
+	description = strings.Map(func(c rune) rune {
+		if !unicode.IsPrint(c) {
+			return -1
+		}
+		return c
+	}, description)
+	if runes := []rune(description); len(runes) > maxErrorDescription {
+		description = string(runes[:maxErrorDescription])
+	}
+	return strings.TrimSpace(description)
+}
+
+// providerErrorStatus returns the HTTP status of the response to a login that failed with a
+// provider error.
+func providerErrorStatus(err *ProviderError) int {
+	switch {
+	case errors.Is(err, ErrAccessDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrInvalidGrant):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrProviderUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// providerErrorTypes are the stable names of the errors that classify provider errors, that are
+// returned to API clients.
+var providerErrorTypes = map[error]string{
+	ErrAccessDenied:        "access_denied",
+	ErrInvalidGrant:        "invalid_grant",
+	ErrProviderUnavailable: "provider_unavailable",
+	ErrProvider:            "provider_error",
+}
+
+// loginFailed responds to a login that failed. API clients get an RFC 7807 problem with the
+// provider error, if there is one, and browsers get a plain error.
+func (a *Auth) loginFailed(w http.ResponseWriter, r *http.Request, providerErr *ProviderError) {
+	if providerErr == nil || !a.config().APIRequest(r) {
+		if providerErr != nil && errors.Is(providerErr, ErrProviderUnavailable) {
+			http.Error(w, "Provider unavailable, retry later", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "Authorization failure", http.StatusUnauthorized)
+		return
+	}
+	status := providerErrorStatus(providerErr)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        providerErr.Description,
+		Error:         providerErrorTypes[providerErr.Unwrap()],
+		ProviderError: providerErr.Code,
+	})
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestProviderError(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":             "invalid_grant",
+			"error_description": "Code was already redeemed.",
+		})
+	}))
+	defer s.Close()
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{
+			ClientID: "client1",
+			Endpoint: oauth2.Endpoint{AuthURL: s.URL + "/auth", TokenURL: s.URL + "/token"},
+		},
+		Log: t.Logf,
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name             string
+		target           string
+		api              bool
+		wantStatus       int
+		wantError        string
+		wantProviderCode string
+		wantDetail       string
+	}{
+		{
+			name:       "browser",
+			target:     "/auth?error=access_denied&state=/",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:             "access denied",
+			target:           "/auth?error=access_denied&error_description=The+user+cancelled&state=/",
+			api:              true,
+			wantStatus:       http.StatusForbidden,
+			wantError:        "access_denied",
+			wantProviderCode: "access_denied",
+			wantDetail:       "The user cancelled",
+		},
+		{
+			name:             "unavailable",
+			target:           "/auth?error=temporarily_unavailable&state=/",
+			api:              true,
+			wantStatus:       http.StatusServiceUnavailable,
+			wantError:        "provider_unavailable",
+			wantProviderCode: "temporarily_unavailable",
+		},
+		{
+			name:             "unknown code",
+			target:           "/auth?error=invalid_scope&state=/",
+			api:              true,
+			wantStatus:       http.StatusBadGateway,
+			wantError:        "provider_error",
+			wantProviderCode: "invalid_scope",
+		},
+		{
+			name:             "sanitized",
+			target:           "/auth?error=%3Cscript%3E&error_description=line%0Abreak&state=/",
+			api:              true,
+			wantStatus:       http.StatusBadGateway,
+			wantError:        "provider_error",
+			wantProviderCode: "unknown",
+			wantDetail:       "linebreak",
+		},
+		{
+			name:             "token error",
+			target:           "/auth?code=code&state=/",
+			api:              true,
+			wantStatus:       http.StatusBadRequest,
+			wantError:        "invalid_grant",
+			wantProviderCode: "invalid_grant",
+			wantDetail:       "Code was already redeemed.",
+		},
+		{
+			name:       "token error browser",
+			target:     "/auth?code=code&state=/",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.api {
+				req.Header.Set("Accept", "application/json")
+			}
+			rec := httptest.NewRecorder()
+			a.RedirectHandler().ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if !tt.api {
+				assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+				return
+			}
+			assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+			var got problem
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+			assert.Equal(t, tt.wantStatus, got.Status)
+			assert.Equal(t, tt.wantError, got.Error)
+			assert.Equal(t, tt.wantProviderCode, got.ProviderError)
+			assert.Equal(t, tt.wantDetail, got.Detail)
+		})
+	}
+}
+
+func TestProviderErrorIs(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, errors.Is(newProviderError("access_denied", ""), ErrAccessDenied))
+	assert.True(t, errors.Is(newProviderError("login_required", ""), ErrAccessDenied))
+	assert.True(t, errors.Is(newProviderError("invalid_grant", ""), ErrInvalidGrant))
+	assert.True(t, errors.Is(newProviderError("server_error", ""), ErrProviderUnavailable))
+	assert.True(t, errors.Is(newProviderError("invalid_request", ""), ErrProvider))
+	assert.EqualError(t, newProviderError("access_denied", "cancelled"), "provider error access_denied: cancelled")
+}
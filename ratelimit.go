@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// rateLimitedBody is the body of the responses of rateLimitTransport to rate limited requests,
+// which is decoded as a temporarily_unavailable OAuth2 error.
+const rateLimitedBody = `{"error":"temporarily_unavailable","error_description":"Provider rate limited, retry later"}`
+
+// rateLimitTransport honors the Retry-After header of rate limited responses of the provider.
+// A request that is rate limited is retried once if the provider asks to wait no more than
+// Config.MaxRetryAfter, and the request deadline allows it. Otherwise, requests to the provider
+// host fail without being sent until the Retry-After time passes, to save the client quota.
+type rateLimitTransport struct {
+	base http.RoundTripper
+	a    *Auth
+}
+
+func (t *rateLimitTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if until, ok := t.a.rateLimited(r.URL.Host); ok {
+		return rateLimitedResponse(r, time.Until(until)), nil
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	wait, ok := retryAfter(resp)
+	if !ok {
+		return resp, nil
+	}
+
+	if wait <= t.a.config().MaxRetryAfter && (r.Body == nil || r.GetBody != nil) && withinDeadline(r, wait) {
+		resp.Body.Close()
+		retry := r.Clone(r.Context())
+		if r.GetBody != nil {
+			retry.Body, err = r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+		resp, err = t.base.RoundTrip(retry)
+		if err != nil {
+			return nil, err
+		}
+		if wait, ok = retryAfter(resp); !ok {
+			return resp, nil
+		}
+	}
+
+	resp.Body.Close()
+	t.a.setRateLimited(r.URL.Host, time.Now().Add(wait))
+	return rateLimitedResponse(r, wait), nil
+}
+
+// withinDeadline returns whether the request can wait for the given duration before its
+// deadline.
+func withinDeadline(r *http.Request, wait time.Duration) bool {
+	deadline, ok := r.Context().Deadline()
+	return !ok || time.Now().Add(wait).Before(deadline)
+}
+
+// retryAfter returns the duration from the Retry-After header of a rate limited or unavailable
+// response, and whether the response is such a response.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, resp.StatusCode == http.StatusTooManyRequests
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			seconds = 0
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, resp.StatusCode == http.StatusTooManyRequests
+}
+
+// rateLimitedResponse returns a rate limited response to the request, with a JSON body that is
+// decoded by the oauth2 package.
+func rateLimitedResponse(r *http.Request, wait time.Duration) *http.Response {
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	header.Set("Retry-After", retryAfterValue(wait))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests)),
+		StatusCode:    http.StatusTooManyRequests,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(rateLimitedBody)),
+		ContentLength: int64(len(rateLimitedBody)),
+		Request:       r,
+	}
+}
+
+// retryAfterValue returns the Retry-After header value of the duration, in whole seconds,
+// rounded up.
+func retryAfterValue(wait time.Duration) string {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	if seconds < 0 {
+		seconds = 0
+	}
+	return strconv.Itoa(seconds)
+}
+
+// rateLimited returns whether requests to the host are rate limited, and until when.
+func (a *Auth) rateLimited(host string) (time.Time, bool) {
+	a.rateLimitsMu.Lock()
+	defer a.rateLimitsMu.Unlock()
+	until, ok := a.rateLimits[host]
+	if ok && !time.Now().Before(until) {
+		delete(a.rateLimits, host)
+		return time.Time{}, false
+	}
+	return until, ok
+}
+
+// setRateLimited marks the requests to the host as rate limited until the given time.
+func (a *Auth) setRateLimited(host string, until time.Time) {
+	a.rateLimitsMu.Lock()
+	defer a.rateLimitsMu.Unlock()
+	if a.rateLimits == nil {
+		a.rateLimits = make(map[string]time.Time)
+	}
+	a.rateLimits[host] = until
+}
+
+// rateLimitError returns the Retry-After duration if the error is a rate limited response of
+// the provider.
+func rateLimitError(err error) (time.Duration, bool) {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) || retrieveErr.Response == nil ||
+		retrieveErr.Response.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	seconds, _ := strconv.Atoi(retrieveErr.Response.Header.Get("Retry-After"))
+	return time.Duration(seconds) * time.Second, true
+}
+
+// providerRateLimited responds to a request that failed since the provider is rate limited.
+func providerRateLimited(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", retryAfterValue(wait))
+	http.Error(w, "Provider rate limited, retry later", http.StatusServiceUnavailable)
+}
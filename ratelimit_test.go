@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		wantWait   time.Duration
+		wantOK     bool
+	}{
+		{name: "ok", status: http.StatusOK, retryAfter: "10"},
+		{name: "seconds", status: http.StatusTooManyRequests, retryAfter: "10", wantWait: 10 * time.Second, wantOK: true},
+		{name: "unavailable", status: http.StatusServiceUnavailable, retryAfter: "10", wantWait: 10 * time.Second, wantOK: true},
+		{name: "past date", status: http.StatusTooManyRequests, retryAfter: "Wed, 21 Oct 2015 07:28:00 GMT", wantOK: true},
+		{name: "missing", status: http.StatusTooManyRequests, wantOK: true},
+		{name: "unavailable missing", status: http.StatusServiceUnavailable},
+		{name: "invalid", status: http.StatusServiceUnavailable, retryAfter: "soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+			wait, ok := retryAfter(resp)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantWait, wait)
+		})
+	}
+
+	t.Run("date", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+		resp.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+		wait, ok := retryAfter(resp)
+		assert.True(t, ok)
+		assert.InDelta(t, time.Minute.Seconds(), wait.Seconds(), 2)
+	})
+}
+
+func TestRateLimitedExchange(t *testing.T) {
+	t.Parallel()
+
+	newProvider := func(t *testing.T, retryAfter string, limited int32) (*httptest.Server, *int32) {
+		var requests int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) <= limited {
+				w.Header().Set("Retry-After", retryAfter)
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte("<html>Too many requests</html>"))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"token_type": "Bearer", "access_token": "access", "id_token": "id token"})
+		}))
+		return s, &requests
+	}
+	newAuth := func(t *testing.T, s *httptest.Server, maxRetryAfter time.Duration) *Auth {
+		a, err := New(context.Background(), Config{
+			Config: oauth2.Config{
+				ClientID: "client1",
+				// A fixed auth style, since the oauth2 package retries with another auth style when
+				// the style is auto detected.
+				Endpoint: oauth2.Endpoint{AuthURL: s.URL + "/auth", TokenURL: s.URL + "/token", AuthStyle: oauth2.AuthStyleInHeader},
+			},
+			MaxRetryAfter: maxRetryAfter,
+			Log:           t.Logf,
+		})
+		require.NoError(t, err)
+		return a
+	}
+	callback := func(api bool) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/auth?code=code&state=/", nil)
+		if api {
+			r.Header.Set("Accept", "application/json")
+		}
+		return r
+	}
+
+	t.Run("fail fast", func(t *testing.T) {
+		s, requests := newProvider(t, "30", 100)
+		defer s.Close()
+		a := newAuth(t, s, time.Second)
+
+		rec := httptest.NewRecorder()
+		a.RedirectHandler().ServeHTTP(rec, callback(true))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+		var got problem
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+		assert.Equal(t, "provider_unavailable", got.Error)
+
+		// Following requests are not sent to the provider until the Retry-After time.
+		rec = httptest.NewRecorder()
+		a.RedirectHandler().ServeHTTP(rec, callback(false))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+		assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		s, requests := newProvider(t, "0", 1)
+		defer s.Close()
+		a := newAuth(t, s, time.Second)
+
+		rec := httptest.NewRecorder()
+		a.RedirectHandler().ServeHTTP(rec, callback(false))
+		assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+		assert.Equal(t, int32(2), atomic.LoadInt32(requests))
+	})
+
+	t.Run("retry once", func(t *testing.T) {
+		s, requests := newProvider(t, "0", 2)
+		defer s.Close()
+		a := newAuth(t, s, time.Second)
+
+		rec := httptest.NewRecorder()
+		a.RedirectHandler().ServeHTTP(rec, callback(false))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, int32(2), atomic.LoadInt32(requests))
+	})
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// refreshReuseGrace is the duration after a refresh token rotation, in which the rotated
+	// refresh token is expected from concurrent requests of the same session.
+	refreshReuseGrace = time.Minute
+	// rotatedRefreshTokenTTL is the duration that rotated refresh tokens are remembered.
+	rotatedRefreshTokenTTL = 24 * time.Hour
+)
+
+// refresh renews the token of a session. Concurrent requests with the same refresh token share a
+// single renewal, such that with refresh token rotation they all get the rotated token, instead
+// of presenting the rotated refresh token to the provider.
+func (a *Auth) refresh(r *http.Request, t *token) (*oauth2.Token, error) {
+	cfg := a.config()
+	current := t.toOauth2()
+	if !current.Expiry.IsZero() {
+		// The oauth2 package decides whether to refresh by the real time, therefore the expiry
+		// is shifted such that the token expires by the configured clock.
+		current.Expiry = time.Now().Add(current.Expiry.Sub(a.now()))
+	}
+	if t.RefreshToken == "" {
+		return cfg.TokenSource(a.providerContext(r.Context()), current).Token()
+	}
+	v, err := a.refreshes.do(r.Context(), refreshTokenHash(t.RefreshToken), func() (interface{}, error) {
+		ctx, cancel := a.flightContext()
+		defer cancel()
+		newToken, err := cfg.TokenSource(a.providerContext(ctx), current).Token()
+		if err != nil {
+			return nil, err
+		}
+		a.refreshRotated(t.RefreshToken, newToken.RefreshToken)
+		return newToken, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*oauth2.Token), nil
+}
+
+// refreshTokenHash returns the hash of a refresh token, such that refresh tokens are not kept in
+// memory.
+func refreshTokenHash(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// refreshRotated records that a refresh token was replaced by a new one, when DetectRefreshReuse
+// is set.
+func (a *Auth) refreshRotated(old, new string) {
+	if !a.config().DetectRefreshReuse || new == "" || new == old {
+		return
+	}
+	now := a.now()
+	a.rotatedRefreshTokensMu.Lock()
+	defer a.rotatedRefreshTokensMu.Unlock()
+	if a.rotatedRefreshTokens == nil {
+		a.rotatedRefreshTokens = make(map[string]time.Time)
+	}
+	// Drop old rotations, such that the rotations of all sessions don't pile up.
+	for hash, rotatedAt := range a.rotatedRefreshTokens {
+		if now.Sub(rotatedAt) > rotatedRefreshTokenTTL {
+			delete(a.rotatedRefreshTokens, hash)
+		}
+	}
+	a.rotatedRefreshTokens[refreshTokenHash(old)] = now
+}
+
+// refreshTokenRotatedAt returns the time that the refresh token was rotated, and whether it is
+// known to be rotated.
+func (a *Auth) refreshTokenRotatedAt(refreshToken string) (time.Time, bool) {
+	a.rotatedRefreshTokensMu.Lock()
+	defer a.rotatedRefreshTokensMu.Unlock()
+	rotatedAt, ok := a.rotatedRefreshTokens[refreshTokenHash(refreshToken)]
+	return rotatedAt, ok
+}
+
+// isInvalidGrant returns whether a token refresh failed since the provider rejected the refresh
+// token.
+func isInvalidGrant(err error) bool {
+	providerErr := tokenError(err)
+	return providerErr != nil && providerErr.Code == "invalid_grant"
+}
+
+// refreshTokenRejected handles a refresh token that was rejected by the provider. With refresh
+// token rotation, the provider rejects refresh tokens that were already used, therefore a
+// rejected refresh token that is known to be rotated, and was not rotated by a concurrent
+// request, may have been stolen and used by someone else. When DetectRefreshReuse is set, all the
+// sessions of the user are revoked in this case.
+//
+// The session cookie is not signed, therefore the user is taken from the session ID token only
+// after its signature is verified. The ID token is usually expired at this point, and the expiry
+// is ignored.
+func (a *Auth) refreshTokenRejected(r *http.Request, t *token) {
+	if !a.config().DetectRefreshReuse {
+		return
+	}
+	rotatedAt, ok := a.refreshTokenRotatedAt(t.RefreshToken)
+	if !ok {
+		return
+	}
+	if a.now().Sub(rotatedAt) < refreshReuseGrace {
+		a.logr(r.Context(), "Rejected refresh token was rotated by a concurrent request")
+		return
+	}
+	subject, err := a.sessionSubject(r.Context(), t.IDToken)
+	if err != nil {
+		a.logr(r.Context(), "Security event: rejected refresh token of an unverified session: %v", err)
+		return
+	}
+	a.revoke(subject)
+	a.audit(r, AuditEvent{Type: AuditRevoke, Subject: subject, Reason: "refresh token reuse"})
+	a.logr(r.Context(), "Security event: refresh token of subject %q was rejected, possibly reused. Revoked all sessions.", subject)
+}
+
+// sessionSubject returns the subject of an ID token of a session, after verifying the token
+// signature and audience, but not its expiry.
+func (a *Auth) sessionSubject(ctx context.Context, idToken string) (string, error) {
+	cfg := a.config()
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	err := decodeTokenSegment(idToken, 1, &claims)
+	if err != nil {
+		return "", err
+	}
+	issuer := claims.Issuer
+	switch {
+	case len(cfg.AllowedIssuers) > 0 && !contains(cfg.AllowedIssuers, issuer):
+		return "", fmt.Errorf("issuer %q is not allowed", issuer)
+	case len(cfg.AllowedIssuers) == 0 && !contains(googleIssuers, issuer):
+		return "", fmt.Errorf("issuer %q is not a Google issuer", issuer)
+	}
+	if contains(googleIssuers, issuer) {
+		// Google keys are discovered with the issuer URL.
+		issuer = "https://accounts.google.com"
+	}
+
+	mapClaims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(idToken, mapClaims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing algorithm %q", t.Header["alg"])
+		}
+		keyID, _ := t.Header["kid"].(string)
+		return a.issuerKey(ctx, issuer, keyID)
+	})
+	var validationErr *jwt.ValidationError
+	if err != nil && !(errors.As(err, &validationErr) && validationErr.Errors == jwt.ValidationErrorExpired) {
+		return "", err
+	}
+	err = verifyAudience(mapClaims, cfg.ClientID)
+	if err != nil {
+		return "", err
+	}
+	subject, _ := mapClaims["sub"].(string)
+	if subject == "" {
+		return "", fmt.Errorf("missing \"sub\" claim")
+	}
+	return subject, nil
+}
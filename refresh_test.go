@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestDetectRefreshReuse(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.New(rand.NewSource(1)), 1024)
+	require.NoError(t, err)
+	issuer := newTestIssuer(t, privateKey, "keyid")
+	defer issuer.Close()
+
+	expired := time.Now().Add(-time.Hour).Unix()
+	loginAt := time.Now().Add(-2 * time.Hour).Unix()
+
+	tests := []struct {
+		name       string
+		detect     bool
+		key        *rsa.PrivateKey
+		aud        interface{}
+		rotated    bool
+		wantRevoke bool
+	}{
+		{name: "rotated", detect: true, key: privateKey, rotated: true, wantRevoke: true},
+		{name: "rotated with audience array", detect: true, key: privateKey, aud: []string{"client1"}, rotated: true, wantRevoke: true},
+		{name: "not rotated", detect: true, key: privateKey},
+		{name: "disabled", detect: false, key: privateKey, rotated: true},
+		{name: "forged ID token", detect: true, key: otherKey, rotated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenServer, _ := newRotatingTokenServer(t, privateKey, issuer.URL, time.Now)
+			defer tokenServer.Close()
+
+			now := time.Now()
+			a, err := New(context.Background(), Config{
+				Config: oauth2.Config{
+					ClientID: "client1",
+					Endpoint: oauth2.Endpoint{AuthURL: tokenServer.URL + "/auth", TokenURL: tokenServer.URL + "/token", AuthStyle: oauth2.AuthStyleInParams},
+				},
+				AllowedIssuers:     []string{issuer.URL},
+				DetectRefreshReuse: tt.detect,
+				Clock:              func() time.Time { return now },
+				Log:                t.Logf,
+			})
+			require.NoError(t, err)
+
+			aud := tt.aud
+			if aud == nil {
+				aud = "client1"
+			}
+			idToken := genSignedClaims(t, "keyid", tt.key, jwt.MapClaims{
+				"iss": issuer.URL, "aud": aud, "sub": "user1", "exp": expired,
+			})
+			refreshToken := "other"
+			if tt.rotated {
+				refreshToken = "r1"
+			}
+			session := newTokenCookie(t, &token{
+				Token:   &oauth2.Token{AccessToken: "access", RefreshToken: refreshToken, Expiry: time.Unix(expired, 0)},
+				IDToken: idToken,
+				LoginAt: loginAt,
+			})
+			h := a.Authenticate(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+			// The session refreshes and rotates its refresh token.
+			if tt.rotated {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.AddCookie(session)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				require.Equal(t, http.StatusOK, rec.Code)
+			}
+
+			// Later, the refresh token is presented again.
+			now = now.Add(2 * refreshReuseGrace)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(session)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+			assert.Equal(t, tt.wantRevoke, a.revoked("user1", "", time.Now().Add(-time.Second).Unix()))
+		})
+	}
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.New(rand.NewSource(0)), 1024)
+	require.NoError(t, err)
+	issuer := newTestIssuer(t, privateKey, "keyid")
+	defer issuer.Close()
+	tokenServer, refreshes := newRotatingTokenServer(t, privateKey, issuer.URL, time.Now)
+	defer tokenServer.Close()
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{
+			ClientID: "client1",
+			Endpoint: oauth2.Endpoint{AuthURL: tokenServer.URL + "/auth", TokenURL: tokenServer.URL + "/token", AuthStyle: oauth2.AuthStyleInParams},
+		},
+		AllowedIssuers:     []string{issuer.URL},
+		DetectRefreshReuse: true,
+		Log:                t.Logf,
+	})
+	require.NoError(t, err)
+
+	expired := time.Now().Add(-time.Hour).Unix()
+	session := newTokenCookie(t, &token{
+		Token: &oauth2.Token{AccessToken: "access", RefreshToken: "r1", Expiry: time.Unix(expired, 0)},
+		IDToken: genSignedClaims(t, "keyid", privateKey, jwt.MapClaims{
+			"iss": issuer.URL, "aud": "client1", "sub": "user1", "exp": expired,
+		}),
+		LoginAt: time.Now().Add(-2 * time.Hour).Unix(),
+	})
+	h := a.Authenticate(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(session)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Concurrent requests of the session share a single refresh.
+	const n = 5
+	codes := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func() { codes <- serve() }()
+	}
+	for i := 0; i < n; i++ {
+		assert.Equal(t, http.StatusOK, <-codes)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(refreshes))
+
+	// A request that presents the rotated refresh token right after the rotation fails, but it
+	// is not treated as reuse.
+	assert.Equal(t, http.StatusInternalServerError, serve())
+	assert.False(t, a.revoked("user1", "", time.Now().Add(-time.Second).Unix()))
+}
+
+// newRotatingTokenServer returns a token endpoint that rotates refresh tokens: it accepts only the
+// last refresh token that it issued, starting with "r1", and rejects other refresh tokens with
+// "invalid_grant". The issued ID tokens expire in an hour from now. It returns the number of
+// successful refreshes.
+func newRotatingTokenServer(t *testing.T, privateKey *rsa.PrivateKey, issuer string, now func() time.Time) (*httptest.Server, *int32) {
+	var (
+		mu        sync.Mutex
+		current   = "r1"
+		refreshes int32
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Delay the response, such that concurrent requests overlap.
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		mu.Lock()
+		defer mu.Unlock()
+		if r.PostFormValue("refresh_token") != current {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		n := atomic.AddInt32(&refreshes, 1)
+		current = fmt.Sprintf("r%d", n+1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("access%d", n),
+			"token_type":    "Bearer",
+			"refresh_token": current,
+			"expires_in":    3600,
+			"id_token": genSignedClaims(t, "keyid", privateKey, jwt.MapClaims{
+				"iss": issuer, "aud": "client1", "sub": "user1", "exp": now().Add(time.Hour).Unix(),
+			}),
+		})
+	}))
+	return s, &refreshes
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// requestIDHeader is the header of the request ID, that is read from incoming requests and
+	// set on responses.
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength is the maximum length of an incoming request ID. Longer IDs are replaced.
+	maxRequestIDLength = 128
+)
+
+// RequestID returns the ID of the request, for correlating the request with the authentication
+// logs. It is taken from the incoming "X-Request-ID" header, or generated when the header is
+// missing. It returns an empty string if the request did not go through the authentication
+// handlers.
+func RequestID(ctx context.Context) string {
+	v, _ := ctx.Value(requestIDKey).(string)
+	return v
+}
+
+// handler wraps the handlers of the package with the request ID, and with the Content-Security-Policy
+// if Config.CSPNonce is set.
+func (a *Auth) handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withRequestID(w, r)
+		if a.config().CSPNonce {
+			nr, err := withCSPNonce(w, r)
+			if err != nil {
+				a.logr(r.Context(), "Failed setting CSP: %v", err)
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+			r = nr
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// withRequestID returns the request with its request ID in the context, and sets the request ID
+// on the response. Requests that already passed through the authentication handlers keep their
+// request ID.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := RequestID(r.Context())
+	if id == "" {
+		id = r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, id))
+	}
+	w.Header().Set(requestIDHeader, id)
+	return r
+}
+
+// validRequestID returns whether an incoming request ID can be used in logs and responses.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		// Should never happen, the request can still be served without a unique ID.
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// logr logs with the request ID of the context.
+func (a *Auth) logr(ctx context.Context, format string, args ...interface{}) {
+	if id := RequestID(ctx); id != "" {
+		format = fmt.Sprintf("[%s] %s", id, format)
+	}
+	a.logf(format, args...)
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	var logs []string
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{ClientID: "client1", RedirectURL: "https://example.org/auth"},
+		Log:    func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) },
+		Client: fakeClient(t, certResp{}),
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		header string
+		wantID func(t *testing.T, id string)
+	}{
+		{
+			name:   "incoming",
+			header: "req-1",
+			wantID: func(t *testing.T, id string) { assert.Equal(t, "req-1", id) },
+		},
+		{
+			name:   "generated",
+			wantID: func(t *testing.T, id string) { assert.Len(t, id, 32) },
+		},
+		{
+			name:   "invalid incoming",
+			header: "req 1\n",
+			wantID: func(t *testing.T, id string) { assert.Len(t, id, 32) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs = nil
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(requestIDHeader, tt.header)
+			}
+			req.AddCookie(&http.Cookie{Name: cookieName, Value: "invalid"})
+			rec := httptest.NewRecorder()
+			a.Authenticate(http.NotFoundHandler()).ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			id := rec.Header().Get(requestIDHeader)
+			tt.wantID(t, id)
+			require.NotEmpty(t, logs)
+			for _, log := range logs {
+				assert.True(t, strings.HasPrefix(log, "["+id+"] "), log)
+			}
+		})
+	}
+
+	// The request ID is available to the wrapped handler.
+	a, err = New(context.Background(), Config{Disable: true})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(requestIDHeader, "req-2")
+	a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "req-2", RequestID(r.Context()))
+	})).ServeHTTP(httptest.NewRecorder(), req)
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("no randomness") }
+
+// TestRequestCSPNonceError is not parallel since it replaces the random source of the CSP nonces.
+func TestRequestCSPNonceError(t *testing.T) {
+	var logs []string
+	a, err := New(context.Background(), Config{
+		Disable:  true,
+		CSPNonce: true,
+		Log:      func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) },
+	})
+	require.NoError(t, err)
+
+	reader := cspNonceRand
+	cspNonceRand = errReader{}
+	defer func() { cspNonceRand = reader }()
+
+	logs = nil
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(requestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	a.Authenticate(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("handler should not be called")
+	})).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Len(t, logs, 1)
+	assert.True(t, strings.HasPrefix(logs[0], "[req-1] Failed setting CSP"), logs[0])
+}
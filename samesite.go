@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// bootstrapCookieName is the name of the SameSite=Lax cookie that holds the session right
+	// after login, when Config.StrictSameSiteBootstrap is set.
+	bootstrapCookieName = cookieName + "_bootstrap"
+	// bootstrapCookieTTL is the lifetime of the bootstrap cookie. It only needs to live until the
+	// redirect from the login callback to the application is followed.
+	bootstrapCookieTTL = 2 * time.Minute
+)
+
+// sessionSameSite returns the SameSite mode of the session cookie.
+func (cfg *Config) sessionSameSite() http.SameSite {
+	if cfg.StrictSameSiteBootstrap {
+		return http.SameSiteStrictMode
+	}
+	return http.SameSiteDefaultMode
+}
+
+// setLoginCookie sets the session cookie of a new login. When Config.StrictSameSiteBootstrap is
+// set, it also sets the bootstrap cookie. Session renewals don't set the bootstrap cookie, such
+// that cross site requests are not authenticated after the login.
+func (a *Auth) setLoginCookie(w http.ResponseWriter, token *token) error {
+	value, err := a.writeCookie(w, token)
+	if err != nil {
+		return err
+	}
+	if a.config().StrictSameSiteBootstrap {
+		a.setBootstrapCookie(w, value)
+	}
+	return nil
+}
+
+// setBootstrapCookie sets the short lived SameSite=Lax cookie with the session value. Browsers
+// don't send the SameSite=Strict session cookie on the redirect chain that starts at the
+// provider, and the bootstrap cookie lets the first navigation back to the application
+// establish the session.
+func (a *Auth) setBootstrapCookie(w http.ResponseWriter, value string) {
+	cfg := a.config()
+	http.SetCookie(w, &http.Cookie{
+		Name:     bootstrapCookieName,
+		Value:    value,
+		Expires:  a.now().Add(bootstrapCookieTTL),
+		MaxAge:   int(bootstrapCookieTTL.Seconds()),
+		Path:     cfg.Path,
+		Secure:   cfg.cookieSecure(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearBootstrapCookie removes the bootstrap cookie.
+func (a *Auth) clearBootstrapCookie(w http.ResponseWriter) {
+	cfg := a.config()
+	http.SetCookie(w, &http.Cookie{
+		Name:     bootstrapCookieName,
+		Value:    "",
+		Expires:  a.now(),
+		MaxAge:   -1,
+		Path:     cfg.Path,
+		Secure:   cfg.cookieSecure(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sessionCookie returns the session cookie of the request. When Config.StrictSameSiteBootstrap
+// is set and the SameSite=Strict cookie was not sent, it falls back to the bootstrap cookie.
+func (a *Auth) sessionCookie(r *http.Request) (*http.Cookie, error) {
+	cookie, err := r.Cookie(cookieName)
+	if (err == http.ErrNoCookie || (err == nil && cookie.Value == "")) && a.config().StrictSameSiteBootstrap {
+		if bootstrap, bootstrapErr := r.Cookie(bootstrapCookieName); bootstrapErr == nil && bootstrap.Value != "" {
+			return bootstrap, nil
+		}
+	}
+	return cookie, err
+}
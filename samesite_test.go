@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestStrictSameSiteBootstrap(t *testing.T) {
+	t.Parallel()
+
+	a := &Auth{cfg: &Config{StrictSameSiteBootstrap: true, Log: t.Logf}}
+
+	// Login sets a Strict session cookie and a Lax bootstrap cookie with the same value.
+	rec := httptest.NewRecorder()
+	require.NoError(t, a.setLoginCookie(rec, &token{Token: &oauth2.Token{}, IDToken: "id token"}))
+	cookies := rec.Result().Cookies()
+	require.Equal(t, 2, len(cookies))
+	assert.Equal(t, cookieName, cookies[0].Name)
+	assert.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite)
+	assert.Equal(t, bootstrapCookieName, cookies[1].Name)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[1].SameSite)
+	assert.Equal(t, cookies[0].Value, cookies[1].Value)
+	assert.Equal(t, int(bootstrapCookieTTL.Seconds()), cookies[1].MaxAge)
+	assert.True(t, cookies[1].HttpOnly)
+
+	// Session renewals set only the Strict session cookie.
+	rec = httptest.NewRecorder()
+	require.NoError(t, a.setCookie(rec, &token{Token: &oauth2.Token{}, IDToken: "renewed"}))
+	cookies = rec.Result().Cookies()
+	require.Equal(t, 1, len(cookies))
+	assert.Equal(t, cookieName, cookies[0].Name)
+	assert.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite)
+
+	session := newTokenCookie(t, &token{Token: &oauth2.Token{}, IDToken: "session"}).Value
+	bootstrap := newTokenCookie(t, &token{Token: &oauth2.Token{}, IDToken: "bootstrap"}).Value
+
+	// The bootstrap cookie is used when the Strict cookie was not sent.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: bootstrapCookieName, Value: bootstrap})
+	got, err := a.getCookie(req)
+	require.NoError(t, err)
+	assert.Equal(t, "bootstrap", got.IDToken)
+
+	// The session cookie is preferred.
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: session})
+	got, err = a.getCookie(req)
+	require.NoError(t, err)
+	assert.Equal(t, "session", got.IDToken)
+
+	// Logout clears both cookies.
+	rec = httptest.NewRecorder()
+	a.clearCookie(rec)
+	cookies = rec.Result().Cookies()
+	require.Equal(t, 2, len(cookies))
+	assert.Equal(t, "", cookies[0].Value)
+	assert.Equal(t, bootstrapCookieName, cookies[1].Name)
+	assert.Equal(t, "", cookies[1].Value)
+}
+
+func TestStrictSameSiteBootstrapDisabled(t *testing.T) {
+	t.Parallel()
+
+	a := &Auth{cfg: &Config{Log: t.Logf}}
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, a.setCookie(rec, &token{Token: &oauth2.Token{}, IDToken: "id token"}))
+	cookies := rec.Result().Cookies()
+	require.Equal(t, 1, len(cookies))
+	assert.Equal(t, http.SameSite(0), cookies[0].SameSite)
+
+	// The bootstrap cookie is ignored.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: bootstrapCookieName, Value: newTokenCookie(t, &token{Token: &oauth2.Token{}, IDToken: "bootstrap"}).Value})
+	got, err := a.getCookie(req)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// grantedScopes returns the scopes that were granted in a token response. Providers may omit
+// the scope field when the granted scopes are identical to the requested scopes.
+func grantedScopes(t *oauth2.Token, requested []string) []string {
+	scope, _ := t.Extra("scope").(string)
+	if scope == "" {
+		return requested
+	}
+	return strings.Fields(scope)
+}
+
+// missingScopes returns the requested scopes that were not granted. Google scopes are compared by
+// their short names, since Google returns the URL form of scopes that were requested by their
+// short name.
+func missingScopes(requested, granted []string) []string {
+	normalized := make([]string, 0, len(granted))
+	for _, s := range granted {
+		normalized = append(normalized, normalizeScope(s))
+	}
+	var missing []string
+	for _, s := range requested {
+		if !contains(normalized, normalizeScope(s)) {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// googleScopeNames maps the URL form of Google scopes to their short names.
+var googleScopeNames = map[string]string{
+	"https://www.googleapis.com/auth/userinfo.email":   "email",
+	"https://www.googleapis.com/auth/userinfo.profile": "profile",
+}
+
+// normalizeScope returns the short name of a Google scope, or the scope itself.
+func normalizeScope(scope string) string {
+	if name, ok := googleScopeNames[scope]; ok {
+		return name
+	}
+	return scope
+}
+
+// googleScopes are the Google scopes that don't have the URL form.
+var googleScopes = []string{"openid", "email", "profile"}
+
+// googleScopePrefixes are the prefixes of the documented Google API scopes.
+var googleScopePrefixes = []string{
+	"https://www.googleapis.com/auth/",
+	"https://mail.google.com/",
+	"https://www.google.com/m8/feeds",
+	"https://www.google.com/calendar/feeds",
+}
+
+// checkScopes validates the requested scopes. It returns an error for malformed scopes, and
+// warnings for scopes that are unknown for the provider, which might be typos.
+func checkScopes(scopes []string, endpoint oauth2.Endpoint) (warnings []string, err error) {
+	for i, s := range scopes {
+		switch {
+		case s == "":
+			return nil, fmt.Errorf("scope %d is empty", i)
+		case strings.ContainsAny(s, " \t\n,"):
+			return nil, fmt.Errorf("scope %q should be given as separate scopes", s)
+		case contains(scopes[:i], s):
+			return nil, fmt.Errorf("scope %q is duplicated", s)
+		}
+	}
+
+	if endpoint.AuthURL != google.Endpoint.AuthURL {
+		// Other providers scopes are not known.
+		return nil, nil
+	}
+	if !contains(scopes, "openid") {
+		warnings = append(warnings, `scope "openid" is missing, the login will not return an ID token`)
+	}
+	for _, s := range scopes {
+		if !isGoogleScope(s) {
+			warnings = append(warnings, fmt.Sprintf("scope %q is not a known Google scope", s))
+		}
+	}
+	return warnings, nil
+}
+
+func isGoogleScope(scope string) bool {
+	if contains(googleScopes, scope) {
+		return true
+	}
+	for _, prefix := range googleScopePrefixes {
+		if strings.HasPrefix(scope, prefix) && scope != googleScopePrefixes[0] {
+			return true
+		}
+	}
+	return false
+}
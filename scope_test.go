@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+func TestScopeDowngrade(t *testing.T) {
+	t.Parallel()
+
+	requested := []string{"openid", "email", "calendar"}
+	tests := []struct {
+		name        string
+		scope       string
+		onDowngrade func(requested, granted []string) error
+		wantStatus  int
+		wantGranted []string
+	}{
+		{
+			name:       "all granted",
+			scope:      "openid email calendar",
+			wantStatus: http.StatusTemporaryRedirect,
+		},
+		{
+			name:        "url forms",
+			scope:       "openid https://www.googleapis.com/auth/userinfo.email calendar",
+			onDowngrade: func(requested, granted []string) error { return fmt.Errorf("not a downgrade") },
+			wantStatus:  http.StatusTemporaryRedirect,
+		},
+		{
+			name:       "scope omitted",
+			wantStatus: http.StatusTemporaryRedirect,
+		},
+		{
+			name:        "downgrade",
+			scope:       "openid email",
+			wantStatus:  http.StatusTemporaryRedirect,
+			wantGranted: []string{"openid", "email"},
+		},
+		{
+			name:  "downgrade allowed",
+			scope: "openid email",
+			onDowngrade: func(gotRequested, granted []string) error {
+				assert.Equal(t, requested, gotRequested)
+				assert.Equal(t, []string{"openid", "email"}, granted)
+				return nil
+			},
+			wantStatus:  http.StatusTemporaryRedirect,
+			wantGranted: []string{"openid", "email"},
+		},
+		{
+			name:        "downgrade denied",
+			scope:       "openid email",
+			onDowngrade: func(requested, granted []string) error { return fmt.Errorf("calendar is required") },
+			wantStatus:  http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oauth2Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"token_type":   "Bearer",
+					"access_token": "access",
+					"id_token":     "id token",
+					"scope":        tt.scope,
+				})
+			}))
+			defer oauth2Server.Close()
+
+			a, err := New(context.Background(), Config{
+				Config: oauth2.Config{
+					ClientID: "client1",
+					Scopes:   requested,
+					Endpoint: oauth2.Endpoint{AuthURL: oauth2Server.URL + "/auth", TokenURL: oauth2Server.URL + "/token"},
+				},
+				OnScopeDowngrade: tt.onDowngrade,
+				Log:              t.Logf,
+			})
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			a.RedirectHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth?code=code&state=/", nil))
+			require.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusTemporaryRedirect {
+				assert.Empty(t, rec.Result().Cookies())
+				return
+			}
+
+			require.Equal(t, 1, len(rec.Result().Cookies()))
+			decoded, err := base64.StdEncoding.DecodeString(rec.Result().Cookies()[0].Value)
+			require.NoError(t, err)
+			var got token
+			err = json.Unmarshal(decoded, &got)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantGranted, got.GrantedScopes)
+		})
+	}
+}
+
+func TestCheckScopes(t *testing.T) {
+	t.Parallel()
+
+	other := oauth2.Endpoint{AuthURL: "https://example.com/auth", TokenURL: "https://example.com/token"}
+	tests := []struct {
+		name         string
+		scopes       []string
+		endpoint     oauth2.Endpoint
+		wantErr      bool
+		wantWarnings int
+	}{
+		{name: "default", scopes: defaultScopes, endpoint: google.Endpoint},
+		{name: "short google scopes", scopes: []string{"openid", "email", "profile", "https://mail.google.com/"}, endpoint: google.Endpoint},
+		{name: "typo", scopes: []string{"openid", "emial"}, endpoint: google.Endpoint, wantWarnings: 1},
+		{name: "missing openid", scopes: []string{"email"}, endpoint: google.Endpoint, wantWarnings: 1},
+		{name: "custom provider scope", scopes: []string{"openid", "read:org"}, endpoint: other},
+		{name: "empty", scopes: []string{"openid", ""}, endpoint: other, wantErr: true},
+		{name: "joined", scopes: []string{"openid email"}, endpoint: other, wantErr: true},
+		{name: "comma joined", scopes: []string{"openid,email"}, endpoint: google.Endpoint, wantErr: true},
+		{name: "duplicate", scopes: []string{"openid", "openid"}, endpoint: other, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := checkScopes(tt.scopes, tt.endpoint)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, warnings, tt.wantWarnings, warnings)
+		})
+	}
+
+	_, err := New(context.Background(), Config{Config: oauth2.Config{Scopes: []string{"openid email"}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// SessionInfo is the metadata of the session of the logged in user.
+type SessionInfo struct {
+	// LoginAt is the time of the login that created the session. It is zero for sessions that were
+	// created before it was recorded.
+	LoginAt time.Time
+	// ExpiresAt is the time that the ID token of the session expires. After this time, the
+	// session is renewed on the next request, or the user needs to login again when the refresh
+	// token is not stored.
+	ExpiresAt time.Time
+	// Scopes are the OAuth2 scopes that were granted to the session.
+	Scopes []string
+}
+
+// Session returns the session metadata of the logged in user. It returns nil in case that there
+// is no user information, same as `User`.
+func Session(ctx context.Context) *SessionInfo {
+	v := ctx.Value(sessionKey)
+	if v == nil {
+		return nil
+	}
+	return v.(*SessionInfo)
+}
+
+// userInfo is the response of the UserInfoHandler.
+type userInfo struct {
+	Subject   string     `json:"subject"`
+	Email     string     `json:"email"`
+	Name      string     `json:"name"`
+	Picture   string     `json:"picture,omitempty"`
+	LoginAt   *time.Time `json:"loginAt,omitempty"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+}
+
+// UserInfoHandler returns an authenticated handler that responds with the logged in user and the
+// session expiry as JSON. Single page applications can use it to show the user, and to schedule
+// a renewal before the session expires. Sessions have no idle timeout, they only expire with the
+// ID token, therefore only the absolute "expiresAt" is reported.
+func (a *Auth) UserInfoHandler() http.Handler {
+	return a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creds := User(r.Context())
+		session := Session(r.Context())
+		if creds == nil || session == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		err := json.NewEncoder(w).Encode(newUserInfo(creds, session))
+		if err != nil {
+			a.logr(r.Context(), "Failed writing user info: %v", err)
+		}
+	}))
+}
+
+// newUserInfo returns the user information that is exposed to the browser.
+func newUserInfo(creds *Creds, session *SessionInfo) userInfo {
+	info := userInfo{
+		Subject:   creds.Subject,
+		Email:     creds.Email,
+		Name:      creds.Name,
+		Picture:   creds.Picture,
+		ExpiresAt: session.ExpiresAt,
+	}
+	if !session.LoginAt.IsZero() {
+		info.LoginAt = &session.LoginAt
+	}
+	return info
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestUserInfoHandler(t *testing.T) {
+	t.Parallel()
+
+	privateKey, privateKeyCert := newTestKey(t)
+
+	a, err := New(context.Background(), Config{
+		Config:          oauth2.Config{ClientID: "client1"},
+		ExpiresAtHeader: true,
+		Log:             t.Logf,
+		Client:          fakeClient(t, certResp{Keys: []cert{privateKeyCert}}),
+	})
+	require.NoError(t, err)
+
+	loginAt := time.Now().Add(-time.Minute).Unix()
+	session := newTokenCookie(t, &token{
+		Token:   &oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)},
+		IDToken: genSignedToken(t, privateKeyCert.KID, privateKey, "client1", "email@example.com", "John"),
+		LoginAt: loginAt,
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.AddCookie(session)
+	rec := httptest.NewRecorder()
+	a.UserInfoHandler().ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+	var got struct {
+		Email     string    `json:"email"`
+		Name      string    `json:"name"`
+		LoginAt   time.Time `json:"loginAt"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	err = json.NewDecoder(rec.Body).Decode(&got)
+	require.NoError(t, err)
+	assert.Equal(t, "email@example.com", got.Email)
+	assert.Equal(t, "John", got.Name)
+	assert.Equal(t, loginAt, got.LoginAt.Unix())
+	assert.InDelta(t, time.Now().Add(time.Hour).Unix(), got.ExpiresAt.Unix(), 5)
+	assert.Equal(t, got.ExpiresAt.UTC().Format(http.TimeFormat), rec.Result().Header.Get("Expires-At"))
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+// SPAPlaceholder is the placeholder in the index.html of a single page application that is
+// replaced by the user data. See `Auth.ServeSPA`.
+const SPAPlaceholder = "<!-- auth:user -->"
+
+// spaScriptID is the ID of the script element that holds the user data.
+const spaScriptID = "auth-user"
+
+// ServeSPA returns an authenticated handler that serves the index.html of a single page
+// application at indexPath, with the logged in user data injected at `SPAPlaceholder`, or before
+// the closing head tag if there is no placeholder. The data is the same as the response of
+// `UserInfoHandler`, in a JSON script element, such that the application doesn't need a round
+// trip to get it:
+//
+//	const user = JSON.parse(document.getElementById("auth-user").textContent);
+//
+// The file is read on every request.
+func (a *Auth) ServeSPA(indexPath string) http.Handler {
+	return a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creds := User(r.Context())
+		session := Session(r.Context())
+		if creds == nil || session == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		index, err := os.ReadFile(indexPath)
+		if err != nil {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			a.logr(r.Context(), "Failed reading SPA index %q: %v", indexPath, err)
+			return
+		}
+		script, err := spaUserScript(newUserInfo(creds, session))
+		if err != nil {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			a.logr(r.Context(), "Failed encoding SPA user data: %v", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_, err = w.Write(injectSPAScript(index, script))
+		if err != nil {
+			a.logr(r.Context(), "Failed writing SPA index: %v", err)
+		}
+	}))
+}
+
+// spaUserScript returns the script element that holds the user data. The JSON encoder escapes
+// '<', '>' and '&', therefore the data can't close the script element or open a comment.
+func spaUserScript(info userInfo) ([]byte, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.WriteString(`<script id="` + spaScriptID + `" type="application/json">`)
+	b.Write(data)
+	b.WriteString(`</script>`)
+	return b.Bytes(), nil
+}
+
+// injectSPAScript injects the script into the index at the placeholder, or before the closing
+// head tag.
+func injectSPAScript(index, script []byte) []byte {
+	if bytes.Contains(index, []byte(SPAPlaceholder)) {
+		return bytes.Replace(index, []byte(SPAPlaceholder), script, 1)
+	}
+	if i := bytes.Index(bytes.ToLower(index), []byte("</head>")); i >= 0 {
+		return append(append(append([]byte{}, index[:i]...), script...), index[i:]...)
+	}
+	return append(script, index...)
+}
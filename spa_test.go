@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestServeSPA(t *testing.T) {
+	t.Parallel()
+
+	privateKey, privateKeyCert := newTestKey(t)
+
+	a, err := New(context.Background(), Config{
+		Config: oauth2.Config{ClientID: "client1"},
+		Log:    t.Logf,
+		Client: fakeClient(t, certResp{Keys: []cert{privateKeyCert}}),
+	})
+	require.NoError(t, err)
+
+	const name = "</script><script>alert(1)</script><!--"
+	session := newSessionCookie(t, genSignedToken(t, privateKeyCert.KID, privateKey, "client1", "email@example.com", name))
+
+	dir := t.TempDir()
+	tests := []struct {
+		name  string
+		index string
+		want  string
+	}{
+		{
+			name:  "placeholder",
+			index: "<html><head></head><body>" + SPAPlaceholder + "</body></html>",
+			want:  "<html><head></head><body><script",
+		},
+		{
+			name:  "head",
+			index: "<html><HEAD><title>App</title></HEAD><body></body></html>",
+			want:  "<html><HEAD><title>App</title><script",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".html")
+			require.NoError(t, os.WriteFile(path, []byte(tt.index), 0600))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(session)
+			rec := httptest.NewRecorder()
+			a.ServeSPA(path).ServeHTTP(rec, req)
+
+			require.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+			assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+			body := rec.Body.String()
+			assert.Contains(t, body, tt.want)
+			// The user name can't break out of the script element.
+			assert.NotContains(t, body, name)
+			assert.Contains(t, body, `</script>`)
+		})
+	}
+
+	t.Run("user data", func(t *testing.T) {
+		user, err := spaUserScript(userInfo{Email: "email@example.com", Name: name})
+		require.NoError(t, err)
+		prefix := `<script id="auth-user" type="application/json">`
+		require.Contains(t, string(user), prefix)
+		var got userInfo
+		data := user[len(prefix) : len(user)-len("</script>")]
+		require.NoError(t, json.Unmarshal(data, &got))
+		assert.Equal(t, name, got.Name)
+	})
+
+	t.Run("missing index", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(session)
+		rec := httptest.NewRecorder()
+		a.ServeSPA(filepath.Join(dir, "missing.html")).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/option"
+)
+
+// enrollmentTTL is the duration that the 2-step verification enrollment of a user is cached.
+const enrollmentTTL = 10 * time.Minute
+
+type enrollment struct {
+	enrolled bool
+	expires  time.Time
+}
+
+// enrolledIn2SV returns whether the user with the given subject is enrolled in 2-step
+// verification, according to the Directory API.
+func (a *Auth) enrolledIn2SV(ctx context.Context, subject string) (bool, error) {
+	a.enrollmentsMu.Lock()
+	e, ok := a.enrollments[subject]
+	a.enrollmentsMu.Unlock()
+	if ok && a.now().Before(e.expires) {
+		return e.enrolled, nil
+	}
+
+	svc, err := admin.NewService(ctx, option.WithHTTPClient(a.config().DirectoryClient))
+	if err != nil {
+		return false, err
+	}
+	// The ID token subject is the Directory API user ID.
+	user, err := svc.Users.Get(subject).Fields("isEnrolledIn2Sv").Context(ctx).Do()
+	if err != nil {
+		return false, err
+	}
+
+	a.enrollmentsMu.Lock()
+	defer a.enrollmentsMu.Unlock()
+	if a.enrollments == nil {
+		a.enrollments = make(map[string]enrollment)
+	}
+	a.enrollments[subject] = enrollment{enrolled: user.IsEnrolledIn2Sv, expires: a.now().Add(enrollmentTTL)}
+	return user.IsEnrolledIn2Sv, nil
+}
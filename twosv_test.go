@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnrolledIn2SV(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	a := &Auth{cfg: &Config{
+		Require2SV: true,
+		DirectoryClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			calls++
+			enrolled := strings.HasSuffix(r.URL.Path, "/users/enrolled")
+			body := `{"isEnrolledIn2Sv":false}`
+			if enrolled {
+				body = `{"isEnrolledIn2Sv":true}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		})},
+	}}
+
+	enrolled, err := a.enrolledIn2SV(context.Background(), "enrolled")
+	require.NoError(t, err)
+	assert.True(t, enrolled)
+
+	enrolled, err = a.enrolledIn2SV(context.Background(), "not-enrolled")
+	require.NoError(t, err)
+	assert.False(t, enrolled)
+
+	// Results are cached.
+	enrolled, err = a.enrolledIn2SV(context.Background(), "enrolled")
+	require.NoError(t, err)
+	assert.True(t, enrolled)
+	assert.Equal(t, 2, calls)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }